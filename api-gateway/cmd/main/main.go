@@ -4,9 +4,11 @@ import (
 	"context"
 	"github.com/kxddry/lectura/api-gateway/internal/config"
 	"github.com/kxddry/lectura/api-gateway/internal/handlers"
+	"github.com/kxddry/lectura/api-gateway/internal/subtitles"
 	"github.com/kxddry/lectura/shared/clients/sso/grpc"
 	config2 "github.com/kxddry/lectura/shared/utils/config"
 	"github.com/kxddry/lectura/shared/utils/ed25519"
+	"github.com/kxddry/lectura/shared/utils/formats"
 	"github.com/kxddry/lectura/shared/utils/logger"
 	middleware2 "github.com/kxddry/lectura/shared/utils/middleware"
 	"github.com/kxddry/lectura/shared/utils/s3"
@@ -43,6 +45,12 @@ func main() {
 		bucket = "input"
 	}
 
+	reg, err := formats.Load(cfg.Formats)
+	if err != nil {
+		log.Error("Invalid formats config", "err", err)
+		os.Exit(1)
+	}
+
 	sql, err := postgres.New(cfg.Storage)
 	if err != nil {
 		panic(err)
@@ -97,7 +105,7 @@ func main() {
 
 	e.POST("/api/v1/login", handlers.Login(ctx, log, auth, auth.AppId, true, cookieName, *auth.AuthPubkey))
 	e.POST("/api/v1/register", handlers.Register(ctx, log, auth, auth.AppId, true, cookieName, *auth.AuthPubkey))
-	e.GET("/api/v1/files", handlers.ListFiles(ctx, log, sql, cli, bucket, cfg.Expiry))
+	e.GET("/api/v1/files", handlers.ListFiles(ctx, log, sql, cli, reg, bucket, cfg.Expiry))
 	e.GET("/api/v1/verify-token", func(c echo.Context) error {
 		if uid := c.Get("uid"); uid != nil {
 			return c.JSON(http.StatusOK, uid)
@@ -105,6 +113,14 @@ func main() {
 		return c.NoContent(http.StatusUnauthorized)
 	})
 	e.GET("/api/v1/file/:uuid", handlers.FileInfo(ctx, log, sql))
+	e.GET("/api/v1/file/:uuid/frames", handlers.ListFrames(ctx, log, sql, cli, bucket, cfg.Expiry))
+	e.GET("/api/v1/file/:uuid/segments", handlers.ListSegments(ctx, log, sql))
+	e.GET("/api/v1/file/:uuid/speakers", handlers.ListSpeakers(ctx, log, sql))
+	e.PUT("/api/v1/file/:uuid/speakers", handlers.RenameSpeakers(ctx, log, sql))
+	e.GET("/api/v1/file/:uuid/transcript", handlers.Transcript(ctx, log, sql, subtitles.Options{
+		LineLength: cfg.Subtitles.LineLength,
+		MaxLines:   cfg.Subtitles.MaxLines,
+	}))
 
 	e.POST("/api/v1/logout", func(c echo.Context) error {
 		c.SetCookie(&http.Cookie{
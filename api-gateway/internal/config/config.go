@@ -6,6 +6,7 @@ import (
 	"github.com/kxddry/lectura/shared/entities/config/db"
 	"github.com/kxddry/lectura/shared/entities/config/s3"
 	"github.com/kxddry/lectura/shared/entities/config/services"
+	"github.com/kxddry/lectura/shared/utils/formats"
 	"time"
 )
 
@@ -21,6 +22,14 @@ type Config struct {
 	PublicKeys  []auth.PublicKeyEntry `yaml:"public_keys"`
 	Storage     db.StorageConfig      `yaml:"storage" env-required:"true"`
 	S3Storage   s3.StorageConfig      `yaml:"s3storage" env-required:"true"`
+	Formats     []formats.Format      `yaml:"formats"` // formats.Default if empty
+	Subtitles   Subtitles             `yaml:"subtitles"`
+}
+
+// Subtitles limit the cues of srt and vtt transcript exports.
+type Subtitles struct {
+	LineLength int `yaml:"line_length" env-default:"42"` // characters per line
+	MaxLines   int `yaml:"max_lines" env-default:"2"`    // lines per cue
 }
 
 type Services struct {
@@ -31,7 +31,7 @@ func FileInfo(ctx context.Context, log *slog.Logger, st InfoStorage) echo.Handle
 
 		data, err := st.GetFileData(ctx, uuid, uid)
 		if err != nil {
-			log.Error("error getting file data", sl.Err(err), data)
+			log.Error("error getting file data", sl.Err(err), slog.String("data", data))
 			return c.String(http.StatusInternalServerError, data)
 		}
 		return c.String(http.StatusOK, data)
@@ -4,12 +4,14 @@ import (
 	"context"
 	"errors"
 	"github.com/kxddry/lectura/shared/entities/frontend"
-	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"github.com/kxddry/lectura/shared/entities/manifest"
+	"github.com/kxddry/lectura/shared/utils/formats"
 	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
 	"github.com/kxddry/lectura/shared/utils/storage"
 	"github.com/labstack/echo/v4"
 	"log/slog"
 	"net/http"
+	"path/filepath"
 	"time"
 )
 
@@ -21,7 +23,8 @@ type FileStorage interface {
 	GetPresignedURL(ctx context.Context, bucket, objectName string, expiry time.Duration) (string, error)
 }
 
-func ListFiles(ctx context.Context, log *slog.Logger, st Storage, fs FileStorage, bucket string, expiry time.Duration) echo.HandlerFunc {
+// ListFiles lists the files of the user. reg maps their extensions to mime types.
+func ListFiles(ctx context.Context, log *slog.Logger, st Storage, fs FileStorage, reg *formats.Registry, bucket string, expiry time.Duration) echo.HandlerFunc {
 	const op = "handler.ListFiles"
 	log = log.With(slog.String("op", op))
 
@@ -45,14 +48,31 @@ func ListFiles(ctx context.Context, log *slog.Logger, st Storage, fs FileStorage
 		}
 
 		for i, file := range out {
-			file.URL, err = fs.GetPresignedURL(ctx, bucket, file.UUID+uploaded.AllowedMimeTypes[file.MimeType], expiry)
+			file.MimeType = reg.MimeType(filepath.Ext(file.Name))
+			file.URL, err = fs.GetPresignedURL(ctx, bucket, renditionKey(file, manifest.Playback, manifest.Original), expiry)
 			if err != nil {
 				log.Error("failed to get file URL", "file", file, sl.Err(err))
 				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get file URL", err)
 			}
+			file.DownloadURL, err = fs.GetPresignedURL(ctx, bucket, renditionKey(file, manifest.Original), expiry)
+			if err != nil {
+				log.Error("failed to get file download URL", "file", file, sl.Err(err))
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get file URL", err)
+			}
 			out[i] = file
 		}
 
 		return c.JSON(http.StatusOK, out)
 	}
 }
+
+// renditionKey returns the key of the first rendition present, in order of preference.
+// Files uploaded before renditions existed only have the ASR WAV.
+func renditionKey(file frontend.File, purposes ...manifest.Purpose) string {
+	for _, p := range purposes {
+		if key, ok := file.Renditions[p]; ok {
+			return key
+		}
+	}
+	return manifest.LegacyKey(file.UUID)
+}
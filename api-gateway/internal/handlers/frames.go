@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/kxddry/lectura/shared/entities/frontend"
+	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
+	"github.com/kxddry/lectura/shared/utils/storage"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type FrameStorage interface {
+	ListFrames(ctx context.Context, uuid string, uid uint) ([]frontend.Frame, error)
+}
+
+// ListFrames returns the slide keyframes of a lecture with presigned image URLs.
+// Audio lectures and videos that are still being processed have none.
+func ListFrames(ctx context.Context, log *slog.Logger, st FrameStorage, fs FileStorage, bucket string, expiry time.Duration) echo.HandlerFunc {
+	const op = "handlers.ListFrames"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, ok := c.Get("uid").(uint)
+		if !ok || uid == 0 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		uuid := c.Param("uuid")
+		if uuid == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "uuid is required")
+		}
+
+		out, err := st.ListFrames(ctx, uuid, uid)
+		if err != nil {
+			if errors.Is(err, storage.ErrUUIDNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, "file not found")
+			}
+			log.Error("list frames", sl.Err(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to list frames")
+		}
+
+		for i := range out {
+			out[i].URL, err = fs.GetPresignedURL(ctx, bucket, out[i].Key, expiry)
+			if err != nil {
+				log.Error("failed to get frame URL", slog.String("key", out[i].Key), sl.Err(err))
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get frame URL")
+			}
+		}
+
+		return c.JSON(http.StatusOK, out)
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/kxddry/lectura/shared/entities/transcribed"
+	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
+	"github.com/kxddry/lectura/shared/utils/storage"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+)
+
+type SegmentStorage interface {
+	ListSegments(ctx context.Context, uuid string, uid uint) ([]transcribed.Segment, error)
+}
+
+// ListSegments returns the timed transcript segments of a lecture, so the UI can seek the recording.
+// Lectures that are not transcribed yet, or were transcribed before segments existed, have none.
+func ListSegments(ctx context.Context, log *slog.Logger, st SegmentStorage) echo.HandlerFunc {
+	const op = "handlers.ListSegments"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, ok := c.Get("uid").(uint)
+		if !ok || uid == 0 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		uuid := c.Param("uuid")
+		if uuid == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "uuid is required")
+		}
+
+		out, err := st.ListSegments(ctx, uuid, uid)
+		if err != nil {
+			if errors.Is(err, storage.ErrUUIDNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, "file not found")
+			}
+			log.Error("list segments", sl.Err(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to list segments")
+		}
+
+		return c.JSON(http.StatusOK, out)
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/kxddry/lectura/shared/entities/frontend"
+	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
+	"github.com/kxddry/lectura/shared/utils/storage"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxSpeakerName = 64
+
+type SpeakerStorage interface {
+	ListSpeakers(ctx context.Context, uuid string, uid uint) ([]frontend.Speaker, error)
+	RenameSpeakers(ctx context.Context, uuid string, uid uint, names map[string]string) error
+}
+
+// ListSpeakers returns the speakers of a lecture transcript with their names, in order of appearance.
+// Transcripts made without diarization have none.
+func ListSpeakers(ctx context.Context, log *slog.Logger, st SpeakerStorage) echo.HandlerFunc {
+	const op = "handlers.ListSpeakers"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, ok := c.Get("uid").(uint)
+		if !ok || uid == 0 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		uuid := c.Param("uuid")
+		if uuid == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "uuid is required")
+		}
+
+		out, err := st.ListSpeakers(ctx, uuid, uid)
+		if err != nil {
+			if errors.Is(err, storage.ErrUUIDNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, "file not found")
+			}
+			log.Error("list speakers", sl.Err(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to list speakers")
+		}
+
+		return c.JSON(http.StatusOK, out)
+	}
+}
+
+// RenameSpeakers names the speakers of a lecture transcript. The body maps labels to names,
+// e.g. {"Speaker 1": "Prof. Smith"}; an empty name resets a speaker to its label.
+func RenameSpeakers(ctx context.Context, log *slog.Logger, st SpeakerStorage) echo.HandlerFunc {
+	const op = "handlers.RenameSpeakers"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, ok := c.Get("uid").(uint)
+		if !ok || uid == 0 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		uuid := c.Param("uuid")
+		if uuid == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "uuid is required")
+		}
+
+		var names map[string]string
+		if err := c.Bind(&names); err != nil || len(names) == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "expected an object of speaker labels to names")
+		}
+		for label, name := range names {
+			name = strings.TrimSpace(name)
+			if utf8.RuneCountInString(name) > maxSpeakerName {
+				return echo.NewHTTPError(http.StatusBadRequest, "speaker names are limited to 64 characters")
+			}
+			names[label] = name
+		}
+
+		if err := st.RenameSpeakers(ctx, uuid, uid, names); err != nil {
+			switch {
+			case errors.Is(err, storage.ErrUUIDNotFound):
+				return echo.NewHTTPError(http.StatusNotFound, "file not found")
+			case errors.Is(err, storage.ErrSpeakerNotFound):
+				return echo.NewHTTPError(http.StatusBadRequest, "unknown speaker label")
+			}
+			log.Error("rename speakers", sl.Err(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to rename speakers")
+		}
+
+		out, err := st.ListSpeakers(ctx, uuid, uid)
+		if err != nil {
+			log.Error("list speakers", sl.Err(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to list speakers")
+		}
+		return c.JSON(http.StatusOK, out)
+	}
+}
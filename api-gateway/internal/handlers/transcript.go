@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kxddry/lectura/api-gateway/internal/subtitles"
+	"github.com/kxddry/lectura/shared/entities/transcribed"
+	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
+	"github.com/kxddry/lectura/shared/utils/storage"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type TranscriptStorage interface {
+	GetTranscript(ctx context.Context, uuid string, uid uint) (string, transcribed.Record, error)
+}
+
+// transcriptFormats maps the format query parameter to the content type of the download.
+var transcriptFormats = map[string]string{
+	"srt":  "application/x-subrip; charset=utf-8",
+	"vtt":  "text/vtt; charset=utf-8",
+	"txt":  "text/plain; charset=utf-8",
+	"json": "application/json; charset=utf-8",
+}
+
+// Transcript exports the transcript of a lecture as subtitles (srt, vtt), plain text (txt, the default) or json,
+// as a download named after the original file.
+func Transcript(ctx context.Context, log *slog.Logger, st TranscriptStorage, opts subtitles.Options) echo.HandlerFunc {
+	const op = "handlers.Transcript"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, ok := c.Get("uid").(uint)
+		if !ok || uid == 0 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		uuid := c.Param("uuid")
+		if uuid == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "uuid is required")
+		}
+
+		format := strings.ToLower(c.QueryParam("format"))
+		if format == "" {
+			format = "txt"
+		}
+		contentType, ok := transcriptFormats[format]
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, "format must be one of srt, vtt, txt, json")
+		}
+
+		name, tr, err := st.GetTranscript(ctx, uuid, uid)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrUUIDNotFound):
+				return echo.NewHTTPError(http.StatusNotFound, "file not found")
+			case errors.Is(err, storage.ErrNotReady):
+				return echo.NewHTTPError(http.StatusConflict, "the file is not transcribed yet")
+			}
+			log.Error("get transcript", sl.Err(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get transcript")
+		}
+		if (format == "srt" || format == "vtt") && len(tr.Segments) == 0 {
+			return echo.NewHTTPError(http.StatusConflict, "the transcript has no timestamps, export it as txt or json")
+		}
+
+		var buf bytes.Buffer
+		switch format {
+		case "srt":
+			err = subtitles.WriteSRT(&buf, subtitles.Cues(tr.Segments, opts))
+		case "vtt":
+			err = subtitles.WriteVTT(&buf, subtitles.Cues(tr.Segments, opts))
+		case "txt":
+			err = subtitles.WriteTXT(&buf, tr.Text, tr.Segments)
+		}
+		if err != nil {
+			log.Error("export transcript", slog.String("format", format), sl.Err(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to export transcript")
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition, contentDisposition(name+"."+format))
+		if format == "json" {
+			return c.JSON(http.StatusOK, tr)
+		}
+		return c.Blob(http.StatusOK, contentType, buf.Bytes())
+	}
+}
+
+// contentDisposition returns an attachment header for name: an ASCII fallback for old clients
+// and the UTF-8 name as filename* (RFC 6266).
+func contentDisposition(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	fallback := strings.Map(func(r rune) rune {
+		if r > 0x7e || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	var encoded strings.Builder
+	for _, b := range []byte(name) {
+		if ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') || strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, encoded.String())
+}
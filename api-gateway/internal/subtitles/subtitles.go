@@ -0,0 +1,193 @@
+package subtitles
+
+import (
+	"fmt"
+	"github.com/kxddry/lectura/shared/entities/transcribed"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Options limit the size of a cue.
+type Options struct {
+	LineLength int // characters per line
+	MaxLines   int // lines per cue; longer segments are split into several cues
+}
+
+// Cue is a piece of subtitle text shown from Start to End, in seconds, already wrapped into lines.
+type Cue struct {
+	Start, End float64
+	Lines      []string
+	Speaker    string // set on the first cue of every speaker turn
+}
+
+// token is a word with its timing.
+type token struct {
+	text       string
+	start, end float64
+}
+
+// Cues splits the segments into cues of at most opts.MaxLines lines of opts.LineLength characters.
+// A segment that needs more lines is split at word boundaries, timed with its word timestamps
+// or, without them, in proportion to the length of the text.
+func Cues(segments []transcribed.Segment, opts Options) []Cue {
+	opts.LineLength = max(opts.LineLength, 1)
+	opts.MaxLines = max(opts.MaxLines, 1)
+
+	var out []Cue
+	speaker := ""
+	for _, s := range segments {
+		tokens := tokenize(s)
+		if len(tokens) == 0 {
+			continue
+		}
+		turn := s.Speaker != "" && s.Speaker != speaker
+		if s.Speaker != "" {
+			speaker = s.Speaker
+		}
+		lines := wrap(tokens, opts.LineLength)
+		for i := 0; i < len(lines); i += opts.MaxLines {
+			group := lines[i:min(i+opts.MaxLines, len(lines))]
+			cue := Cue{Start: group[0][0].start, End: group[len(group)-1][len(group[len(group)-1])-1].end}
+			if i == 0 {
+				cue.Start = s.Start
+				if turn {
+					cue.Speaker = s.Speaker
+				}
+			}
+			if i+opts.MaxLines >= len(lines) {
+				cue.End = s.End
+			}
+			cue.End = max(cue.End, cue.Start)
+			for _, line := range group {
+				cue.Lines = append(cue.Lines, join(line))
+			}
+			out = append(out, cue)
+		}
+	}
+	return out
+}
+
+// tokenize returns the words of s with their timing.
+func tokenize(s transcribed.Segment) []token {
+	var out []token
+	for _, w := range s.Words {
+		if text := strings.TrimSpace(w.Text); text != "" {
+			out = append(out, token{text: text, start: w.Start, end: w.End})
+		}
+	}
+	if len(out) > 0 {
+		return out
+	}
+
+	words := strings.Fields(s.Text)
+	total := 0
+	for _, w := range words {
+		total += utf8.RuneCountInString(w) + 1
+	}
+	pos := 0
+	for _, w := range words {
+		start := s.Start + (s.End-s.Start)*float64(pos)/float64(total)
+		pos += utf8.RuneCountInString(w) + 1
+		out = append(out, token{text: w, start: start, end: s.Start + (s.End-s.Start)*float64(pos)/float64(total)})
+	}
+	return out
+}
+
+// wrap breaks tokens into lines of at most width characters. A word longer than a line gets a line of its own.
+func wrap(tokens []token, width int) [][]token {
+	var lines [][]token
+	var line []token
+	n := 0
+	for _, t := range tokens {
+		l := utf8.RuneCountInString(t.text)
+		if len(line) > 0 && n+1+l > width {
+			lines = append(lines, line)
+			line, n = nil, 0
+		}
+		if len(line) > 0 {
+			n++
+		}
+		line = append(line, t)
+		n += l
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func join(tokens []token) string {
+	words := make([]string, len(tokens))
+	for i, t := range tokens {
+		words[i] = t.text
+	}
+	return strings.Join(words, " ")
+}
+
+// WriteSRT writes the cues as SubRip subtitles. A new speaker is named before the text, "Name: ...".
+func WriteSRT(w io.Writer, cues []Cue) error {
+	for i, c := range cues {
+		text := strings.Join(c.Lines, "\n")
+		if c.Speaker != "" {
+			text = c.Speaker + ": " + text
+		}
+		if _, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, timestamp(c.Start, ','), timestamp(c.End, ','), text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vttEscaper escapes the characters WebVTT treats as markup; it also keeps "-->" out of cue text.
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// WriteVTT writes the cues as WebVTT subtitles. A new speaker is named with a voice span, "<v Name>...".
+func WriteVTT(w io.Writer, cues []Cue) error {
+	if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+		return err
+	}
+	for i, c := range cues {
+		text := vttEscaper.Replace(strings.Join(c.Lines, "\n"))
+		if c.Speaker != "" {
+			text = "<v " + vttEscaper.Replace(c.Speaker) + ">" + text
+		}
+		if _, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, timestamp(c.Start, '.'), timestamp(c.End, '.'), text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTXT writes the plain transcript, a line per segment, or text as is without segments.
+// With speakers, every turn is a paragraph that starts with the name of its speaker.
+func WriteTXT(w io.Writer, text string, segments []transcribed.Segment) error {
+	if len(segments) == 0 {
+		_, err := fmt.Fprintln(w, strings.TrimSpace(text))
+		return err
+	}
+	speaker := ""
+	for i, s := range segments {
+		line := strings.TrimSpace(s.Text)
+		if s.Speaker != "" && s.Speaker != speaker {
+			speaker = s.Speaker
+			line = speaker + ": " + line
+			if i > 0 {
+				line = "\n" + line
+			}
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timestamp formats seconds as HH:MM:SS followed by sep and milliseconds.
+func timestamp(seconds float64, sep byte) string {
+	ms := int64(seconds*1000 + 0.5)
+	if ms < 0 {
+		ms = 0
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
@@ -2,19 +2,26 @@ package main
 
 import (
 	"context"
+	"github.com/kxddry/lectura/asr/internal/backend"
+	"github.com/kxddry/lectura/asr/internal/breaker"
+	"github.com/kxddry/lectura/asr/internal/chunked"
 	config2 "github.com/kxddry/lectura/asr/internal/config"
 	"github.com/kxddry/lectura/asr/internal/handlers"
 
 	// shared tools
+	"github.com/kxddry/lectura/shared/entities/progress"
 	"github.com/kxddry/lectura/shared/entities/transcribed"
 	"github.com/kxddry/lectura/shared/entities/uploaded"
 	"github.com/kxddry/lectura/shared/utils/broker/kafka"
 	"github.com/kxddry/lectura/shared/utils/config"
 	"github.com/kxddry/lectura/shared/utils/logger"
 	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
+	"github.com/kxddry/lectura/shared/utils/reporter"
 	"github.com/kxddry/lectura/shared/utils/s3"
 
+	"expvar"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -43,27 +50,97 @@ func main() {
 
 	log.Debug("minio client created")
 
+	if cfg.Backend.URL == "" {
+		cfg.Backend.URL = cfg.WhisperAPI
+	}
+	b, err := backend.New(log, cfg.Backend)
+	if err != nil {
+		log.Error("invalid backend config", sl.Err(err))
+		os.Exit(1)
+	}
+	go b.Run(ctx)
+	caps := b.Capabilities()
+	log.Info("asr backend", slog.String("type", b.Name()), slog.Any("capabilities", caps), slog.Int("capacity", b.Capacity()))
+	if cfg.Backend.WordTimestamps && !caps.Words {
+		log.Warn("the asr backend has no word timestamps, they are disabled")
+	}
+
+	br := breaker.New(cfg.Breaker.Threshold, cfg.Breaker.OpenFor, func(from, to breaker.State) {
+		switch to {
+		case breaker.Open:
+			log.Warn("asr backend is unhealthy, pausing consumption", slog.Duration("retry_in", cfg.Breaker.OpenFor))
+		case breaker.HalfOpen:
+			log.Info("probing asr backend")
+		case breaker.Closed:
+			log.Info("asr backend recovered, resuming consumption")
+		}
+	})
+
+	if cfg.Chunking.Concurrency == 0 {
+		cfg.Chunking.Concurrency = b.Capacity()
+	}
+	tr, err := chunked.New(log, cfg.Chunking, cfg.Quality, b, br)
+	if err != nil {
+		log.Error("invalid chunking config", sl.Err(err))
+		os.Exit(1)
+	}
+
+	if cfg.Metrics != "" {
+		go serveMetrics(log, cfg.Metrics)
+	}
+
 	r := kafka.NewReader[uploaded.Record](cfg.Kafka.Read)
+	dl := kafka.NewDeadLetters(cfg.Kafka.Read.Brokers, "asr")
+	defer dl.Close()
+	pw, err := kafka.NewWriter[progress.Record](cfg.Kafka.Progress)
+	if err != nil {
+		log.Error("invalid kafka writer config", sl.Err(err))
+		os.Exit(1)
+	}
+	pw.OnDelivery(func(d kafka.Delivery) {
+		if d.Err != nil {
+			log.Warn("progress event lost", slog.String("UUID", d.Key), slog.String("event_id", d.EventID), sl.Err(d.Err))
+		}
+	})
+	defer pw.Close()
+	// the file failed only once the message is given up, earlier errors are retried
+	toDLQ := kafka.ToDeadLetters[uploaded.Record](log, dl)
+	r.OnGiveUp(func(m kafka.Message[uploaded.Record], err error) error {
+		if derr := toDLQ(m, err); derr != nil {
+			return derr
+		}
+		reporter.Fail(ctx, log, pw, m.Value.UUID, progress.ASR, err)
+		return nil
+	})
+	r.OnInvalid(kafka.InvalidToDeadLetters(log, dl))
 	if err = r.CheckAlive(); err != nil {
 		log.Error("CheckAlive failed", sl.Err(err))
 		os.Exit(1)
 	}
-	w := kafka.NewWriter[transcribed.Record](cfg.Kafka.Write)
+	w, err := kafka.NewWriter[transcribed.Record](cfg.Kafka.Write)
+	if err != nil {
+		log.Error("invalid kafka writer config", sl.Err(err))
+		os.Exit(1)
+	}
 
 	kp := kafka.NewPipeline(r, w)
 	log.Debug("kafka clients created")
 
 	// create a worker pool
-	jobs := make(chan uploaded.Record, workerPoolSize*10)
+	// unbuffered, so no more messages are taken than there are free workers
+	jobs := make(chan kafka.Message[uploaded.Record])
 	results := make(chan error, workerPoolSize*10)
 
 	for i := 0; i < workerPoolSize; i++ {
 		go func(id int) {
 			for msg := range jobs {
-				log.Debug("processing msg", slog.String("UUID", msg.UUID))
-				err := handlers.Pipeline(ctx, cfg, cli, kp, msg)
+				log.Debug("processing msg", slog.String("UUID", msg.Value.UUID), slog.String("event_id", msg.Envelope.EventID), slog.Int("attempt", msg.Attempt))
+				err := handlers.Pipeline(kafka.WithCorrelationID(ctx, msg.Envelope.CorrelationID), log, tr, cli, kp, pw, msg.Value)
 				if err != nil {
 					log.Error("error processing job", sl.Err(err))
+					err = msg.Nack(err)
+				} else {
+					err = msg.Ack()
 				}
 				results <- err
 			}
@@ -72,7 +149,7 @@ func main() {
 	}
 	log.Debug("worker pool created")
 
-	go distributeJobs(ctx, log, r, jobs)
+	go distributeJobs(ctx, log, r, br, jobs)
 	log.Debug("job handler started")
 
 	go processResults(log, results)
@@ -85,11 +162,22 @@ func main() {
 	log.Info("signal received, shutting down gracefully")
 }
 
-func distributeJobs[T uploaded.Record](ctx context.Context, log *slog.Logger, r kafka.Reader[T], jobs chan<- T) {
+// distributeJobs hands messages to the workers. It stops taking messages while the breaker is open,
+// so uploads stay in Kafka until the backend is healthy again.
+func distributeJobs[T uploaded.Record](ctx context.Context, log *slog.Logger, r kafka.Reader[T], br *breaker.Breaker, jobs chan<- kafka.Message[T]) {
 	msgCh, errCh := r.Messages(ctx)
 	for {
+		if err := br.Wait(ctx); err != nil {
+			log.Debug("distributor shutting down, ctx done")
+			close(jobs)
+			return
+		}
 		select {
-		case msg := <-msgCh:
+		case msg, ok := <-msgCh:
+			if !ok {
+				close(jobs)
+				return
+			}
 			jobs <- msg
 		case err := <-errCh:
 			log.Error("kafka reader", sl.Err(err))
@@ -111,3 +199,12 @@ func processResults(log *slog.Logger, results <-chan error) {
 		}
 	}
 }
+
+// serveMetrics exposes the expvar counters, e.g. the latency of every asr endpoint, at /debug/vars.
+func serveMetrics(log *slog.Logger, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Error("metrics server", sl.Err(err))
+	}
+}
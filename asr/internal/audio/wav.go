@@ -0,0 +1,112 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+var ErrNotWav = errors.New("not a PCM wav file")
+
+// Wav is a PCM WAV file whose samples are read in windows, so it never has to fit into memory.
+type Wav struct {
+	r          io.ReaderAt
+	format     []byte // raw body of the "fmt " chunk, copied into every window
+	offset     int64  // of the samples
+	size       int64  // of the samples in bytes
+	blockAlign int64  // bytes per sample frame
+	byteRate   int64  // bytes per second
+}
+
+// ParseWav reads the header of the WAV file in r, which is size bytes long. ffmpeg cannot seek back
+// in a pipe to fix up the sizes in the header, so an unknown data size means "until the end of the file".
+func ParseWav(r io.ReaderAt, size int64) (*Wav, error) {
+	const op = "audio.ParseWav"
+
+	head := make([]byte, 12)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrNotWav)
+	}
+	if string(head[:4]) != "RIFF" || string(head[8:]) != "WAVE" {
+		return nil, fmt.Errorf("%s: %w", op, ErrNotWav)
+	}
+
+	w := &Wav{r: r}
+	for pos := int64(12); pos+8 <= size; {
+		chunk := make([]byte, 8)
+		if _, err := r.ReadAt(chunk, pos); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		id, n := string(chunk[:4]), int64(binary.LittleEndian.Uint32(chunk[4:]))
+		pos += 8
+
+		switch id {
+		case "fmt ":
+			if n < 16 || pos+n > size {
+				return nil, fmt.Errorf("%s: %w", op, ErrNotWav)
+			}
+			w.format = make([]byte, n)
+			if _, err := r.ReadAt(w.format, pos); err != nil {
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+		case "data":
+			if w.format == nil {
+				return nil, fmt.Errorf("%s: %w", op, ErrNotWav)
+			}
+			w.offset = pos
+			w.size = n
+			if n == 0 || n == math.MaxUint32 || pos+n > size {
+				w.size = size - pos
+			}
+			return w, w.init()
+		}
+		pos += n + n%2 // chunks are word aligned
+	}
+	return nil, fmt.Errorf("%s: %w", op, ErrNotWav)
+}
+
+func (w *Wav) init() error {
+	tag := binary.LittleEndian.Uint16(w.format[0:])
+	channels := int64(binary.LittleEndian.Uint16(w.format[2:]))
+	rate := int64(binary.LittleEndian.Uint32(w.format[4:]))
+	w.blockAlign = int64(binary.LittleEndian.Uint16(w.format[12:]))
+	if (tag != 1 && tag != 0xFFFE) || channels == 0 || rate == 0 || w.blockAlign == 0 {
+		return fmt.Errorf("audio.ParseWav: %w", ErrNotWav)
+	}
+	w.byteRate = rate * w.blockAlign
+	w.size -= w.size % w.blockAlign
+	return nil
+}
+
+// Duration returns the length of the audio in seconds.
+func (w *Wav) Duration() float64 {
+	return float64(w.size) / float64(w.byteRate)
+}
+
+// Window returns the samples between start and end, in seconds, as a standalone WAV file.
+func (w *Wav) Window(start, end float64) io.Reader {
+	from, to := w.bytes(start), w.bytes(end)
+	if to < from {
+		to = from
+	}
+
+	head := &bytes.Buffer{}
+	head.WriteString("RIFF")
+	_ = binary.Write(head, binary.LittleEndian, uint32(4+8+len(w.format)+8+int(to-from)))
+	head.WriteString("WAVEfmt ")
+	_ = binary.Write(head, binary.LittleEndian, uint32(len(w.format)))
+	head.Write(w.format)
+	head.WriteString("data")
+	_ = binary.Write(head, binary.LittleEndian, uint32(to-from))
+
+	return io.MultiReader(head, io.NewSectionReader(w.r, w.offset+from, to-from))
+}
+
+// bytes converts seconds to a sample-aligned offset into the samples.
+func (w *Wav) bytes(t float64) int64 {
+	b := int64(t*float64(w.byteRate)) / w.blockAlign * w.blockAlign
+	return min(max(b, 0), w.size)
+}
@@ -0,0 +1,109 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"github.com/kxddry/lectura/asr/internal/config"
+	"github.com/kxddry/lectura/asr/internal/entities"
+	"io"
+	"log/slog"
+)
+
+// Capabilities are the optional features a backend supports.
+type Capabilities struct {
+	Segments  bool // timed segments; without them long recordings are cut without overlap
+	Words     bool // word-level timings
+	Language  bool // forcing the spoken language instead of detecting it
+	Translate bool // translating the speech to English
+	Prompt    bool // an initial prompt that steers spelling and style
+	Speakers  bool // labeling segments with who is speaking (diarization)
+	Decoding  bool // changing the sampling temperature, to re-run windows that look hallucinated
+}
+
+// Options change how the speech is recognized. Zero values leave the choice to the backend.
+type Options struct {
+	Language  string // ISO 639-1 code of the spoken language
+	Translate bool
+	Prompt    string
+	Speakers  bool // label the segments with their speaker
+
+	// decoding settings of re-runs
+	Temperature float64 // 0 leaves the default of the backend
+	NoContext   bool    // do not condition on the text of the previous segments, which feeds repetition loops
+}
+
+// Supported drops the options the backend cannot honor.
+func (c Capabilities) Supported(o Options) Options {
+	if !c.Language {
+		o.Language = ""
+	}
+	if !c.Translate {
+		o.Translate = false
+	}
+	if !c.Prompt {
+		o.Prompt = ""
+	}
+	if !c.Speakers {
+		o.Speakers = false
+	}
+	if !c.Decoding {
+		o.Temperature, o.NoContext = 0, false
+	}
+	return o
+}
+
+// Request is a single piece of audio to be transcribed.
+type Request struct {
+	Audio io.Reader // a WAV file
+	Options
+}
+
+// Backend is a speech recognition service.
+type Backend interface {
+	Name() string
+	Capabilities() Capabilities
+	// Health returns an error if the service cannot take requests.
+	Health(ctx context.Context) error
+	Transcribe(ctx context.Context, req Request) (*entities.TranscribeResponse, error)
+}
+
+const (
+	TypeWhisperAPI = "whisper-api"
+	TypeOpenAI     = "openai"
+	TypeFake       = "fake"
+)
+
+// New returns a pool of the endpoints of the backend selected in the config.
+// Run the pool to health check them.
+func New(log *slog.Logger, cfg config.Backend) (*Pool, error) {
+	const op = "backend.New"
+
+	endpoints := cfg.Endpoints
+	if len(endpoints) == 0 && cfg.URL != "" {
+		endpoints = []config.Endpoint{{URL: cfg.URL}}
+	}
+	if len(endpoints) == 0 && cfg.Type == TypeFake {
+		endpoints = []config.Endpoint{{URL: TypeFake}}
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("%s: no endpoints configured", op)
+	}
+
+	client := newClient(cfg)
+	pool := NewPool(log, cfg.Health)
+	for _, e := range endpoints {
+		var b Backend
+		switch cfg.Type {
+		case TypeWhisperAPI:
+			b = NewWhisperAPI(client, e.URL, e.HealthURL, cfg.WordTimestamps, cfg.Diarize)
+		case TypeOpenAI:
+			b = NewOpenAI(client, e.URL, e.HealthURL, cfg.APIKey, cfg.Model, cfg.WordTimestamps, cfg.Diarize)
+		case TypeFake:
+			b = NewFake()
+		default:
+			return nil, fmt.Errorf("%s: unknown backend type %q", op, cfg.Type)
+		}
+		pool.Add(e.URL, b, e.MaxConcurrency)
+	}
+	return pool, nil
+}
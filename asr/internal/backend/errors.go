@@ -0,0 +1,42 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// StatusError is a response other than 200 OK.
+type StatusError struct {
+	Endpoint string
+	Code     int
+	Body     string // the beginning of the response body
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %d %s: %s", e.Endpoint, e.Code, http.StatusText(e.Code), e.Body)
+}
+
+// Retryable reports whether a failed request may succeed if it is sent again: the backend
+// (or every endpoint of a pool) was unreachable, timed out, dropped the connection, was overloaded or failed with a 5xx.
+// Other 4xx responses and undecodable responses are permanent.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code == http.StatusRequestTimeout || se.Code == http.StatusTooManyRequests || se.Code >= 500
+	}
+	var ne net.Error
+	return errors.Is(err, ErrNoEndpoint) ||
+		errors.As(err, &ne) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
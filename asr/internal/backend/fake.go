@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/kxddry/lectura/asr/internal/audio"
+	"github.com/kxddry/lectura/asr/internal/entities"
+	"io"
+	"strings"
+)
+
+// Fake transcribes every WAV file into one numbered sentence per fakeSegment seconds.
+// It needs no model and is meant for tests and local development. A forced language is reported
+// as detected, translations are reported as English. Speakers take turns every fakeTurn segments.
+type Fake struct{}
+
+const (
+	fakeSegment = 5.0
+	fakeTurn    = 3
+)
+
+func NewFake() *Fake { return &Fake{} }
+
+func (f *Fake) Name() string { return TypeFake }
+
+func (f *Fake) Capabilities() Capabilities {
+	return Capabilities{Segments: true, Words: true, Language: true, Translate: true, Prompt: true, Speakers: true}
+}
+
+func (f *Fake) Health(ctx context.Context) error { return nil }
+
+func (f *Fake) Transcribe(ctx context.Context, req Request) (*entities.TranscribeResponse, error) {
+	data, err := io.ReadAll(req.Audio)
+	if err != nil {
+		return nil, err
+	}
+	wav, err := audio.ParseWav(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &entities.TranscribeResponse{Language: "en"}
+	if req.Language != "" && !req.Translate {
+		resp.Language = req.Language
+	}
+	var texts []string
+	for start := 0.0; start < wav.Duration(); start += fakeSegment {
+		end := min(start+fakeSegment, wav.Duration())
+		text := fmt.Sprintf("Sentence number %d.", len(resp.Segments)+1)
+		seg := entities.Segment{ID: len(resp.Segments), Start: start, End: end, Text: text}
+		for i, w := range strings.Fields(text) {
+			step := (end - start) / 3
+			seg.Words = append(seg.Words, entities.Word{Word: w, Start: start + float64(i)*step, End: start + float64(i+1)*step})
+		}
+		if req.Speakers {
+			seg.Speaker = fmt.Sprintf("SPEAKER_%02d", len(resp.Segments)/fakeTurn%2)
+		}
+		resp.Segments = append(resp.Segments, seg)
+		texts = append(texts, text)
+	}
+	resp.Text = strings.Join(texts, " ")
+	return resp, nil
+}
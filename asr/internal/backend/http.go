@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/kxddry/lectura/asr/internal/config"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// newClient returns an HTTP client with the timeouts of the config. The request timeout
+// covers the transcription itself, since the backends answer only once they are done.
+func newClient(cfg config.Backend) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: cfg.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	return &http.Client{Transport: transport, Timeout: cfg.Timeout}
+}
+
+// post streams audio and fields as a multipart form to endpoint and decodes the JSON response into out.
+// The audio is never buffered in memory.
+func post(ctx context.Context, client *http.Client, endpoint string, header http.Header, fields url.Values, audio io.Reader, out any) error {
+	body, writer := io.Pipe()
+	mw := multipart.NewWriter(writer)
+	go func() {
+		var err error
+		for key, values := range fields {
+			for _, v := range values {
+				if err == nil {
+					err = mw.WriteField(key, v)
+				}
+			}
+		}
+		var part io.Writer
+		if err == nil {
+			part, err = mw.CreateFormFile("file", "audio.wav")
+		}
+		if err == nil {
+			_, err = io.Copy(part, audio)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		_ = writer.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		_ = body.Close()
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{Endpoint: endpoint, Code: resp.StatusCode, Body: strings.TrimSpace(string(b))}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// get checks that a GET of endpoint answers 200 OK.
+func get(ctx context.Context, client *http.Client, endpoint string, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Endpoint: endpoint, Code: resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"context"
+	"github.com/kxddry/lectura/asr/internal/entities"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// OpenAI speaks the /v1/audio/transcriptions schema of the OpenAI API, which is also served
+// by faster-whisper-server and the whisper.cpp server.
+type OpenAI struct {
+	client         *http.Client
+	url            string // base URL, without /v1
+	healthUrl      string
+	apiKey         string
+	model          string
+	wordTimestamps bool
+	diarize        bool // model is a diarization model, e.g. gpt-4o-transcribe-diarize
+}
+
+// NewOpenAI returns a client of the server at baseUrl. An empty healthUrl probes /v1/models,
+// which every implementation of the schema serves.
+func NewOpenAI(client *http.Client, baseUrl, healthUrl, apiKey, model string, wordTimestamps, diarize bool) *OpenAI {
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+	if healthUrl == "" {
+		healthUrl = baseUrl + "/v1/models"
+	}
+	return &OpenAI{client: client, url: baseUrl, healthUrl: healthUrl, apiKey: apiKey, model: model, wordTimestamps: wordTimestamps, diarize: diarize}
+}
+
+func (o *OpenAI) Name() string { return TypeOpenAI }
+
+func (o *OpenAI) Capabilities() Capabilities {
+	return Capabilities{Segments: true, Words: true, Language: true, Translate: true, Prompt: true, Speakers: o.diarize, Decoding: true}
+}
+
+func (o *OpenAI) Health(ctx context.Context) error {
+	return get(ctx, o.client, o.healthUrl, o.header())
+}
+
+func (o *OpenAI) header() http.Header {
+	header := http.Header{}
+	if o.apiKey != "" {
+		header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	return header
+}
+
+// openAIResponse is the verbose_json response format. Words are not nested in segments.
+type openAIResponse struct {
+	Text     string             `json:"text"`
+	Language string             `json:"language"`
+	Segments []entities.Segment `json:"segments"`
+	Words    []entities.Word    `json:"words"`
+}
+
+// diarizedResponse is the diarized_json response format: segments with speakers, but no words.
+type diarizedResponse struct {
+	Text     string `json:"text"`
+	Segments []struct {
+		Start   float64 `json:"start"`
+		End     float64 `json:"end"`
+		Text    string  `json:"text"`
+		Speaker string  `json:"speaker"`
+	} `json:"segments"`
+}
+
+// Transcribe uses /v1/audio/translations to translate, which takes neither a language nor timestamp granularities.
+func (o *OpenAI) Transcribe(ctx context.Context, req Request) (*entities.TranscribeResponse, error) {
+	if req.Speakers && !req.Translate {
+		return o.diarized(ctx, req)
+	}
+
+	fields := url.Values{}
+	fields.Set("model", o.model)
+	fields.Set("response_format", "verbose_json")
+	if req.Prompt != "" {
+		fields.Set("prompt", req.Prompt)
+	}
+	// the schema has no switch for conditioning on previous text, so NoContext is ignored
+	if req.Temperature > 0 {
+		fields.Set("temperature", strconv.FormatFloat(req.Temperature, 'f', -1, 64))
+	}
+	endpoint := o.url + "/v1/audio/translations"
+	if !req.Translate {
+		endpoint = o.url + "/v1/audio/transcriptions"
+		if req.Language != "" {
+			fields.Set("language", req.Language)
+		}
+		fields.Add("timestamp_granularities[]", "segment")
+		if o.wordTimestamps {
+			fields.Add("timestamp_granularities[]", "word")
+		}
+	}
+
+	var result openAIResponse
+	if err := post(ctx, o.client, endpoint, o.header(), fields, req.Audio, &result); err != nil {
+		return nil, err
+	}
+
+	resp := &entities.TranscribeResponse{
+		Text:     result.Text,
+		Language: languageCode(result.Language),
+		Segments: result.Segments,
+	}
+	for i := range resp.Segments {
+		resp.Segments[i].Words = nil
+	}
+	attachWords(resp.Segments, result.Words)
+	return resp, nil
+}
+
+// diarized transcribes with speaker labels. Diarization models take no prompt and have no word timings.
+func (o *OpenAI) diarized(ctx context.Context, req Request) (*entities.TranscribeResponse, error) {
+	fields := url.Values{}
+	fields.Set("model", o.model)
+	fields.Set("response_format", "diarized_json")
+	fields.Set("chunking_strategy", "auto")
+	if req.Language != "" {
+		fields.Set("language", req.Language)
+	}
+
+	var result diarizedResponse
+	if err := post(ctx, o.client, o.url+"/v1/audio/transcriptions", o.header(), fields, req.Audio, &result); err != nil {
+		return nil, err
+	}
+
+	resp := &entities.TranscribeResponse{Text: result.Text, Language: req.Language}
+	for i, s := range result.Segments {
+		resp.Segments = append(resp.Segments, entities.Segment{ID: i, Start: s.Start, End: s.End, Text: s.Text, Speaker: s.Speaker})
+	}
+	return resp, nil
+}
+
+// attachWords puts every word into the segment that contains its midpoint. Both are sorted by time.
+func attachWords(segments []entities.Segment, words []entities.Word) {
+	i := 0
+	for _, w := range words {
+		mid := (w.Start + w.End) / 2
+		for i < len(segments)-1 && mid >= segments[i].End {
+			i++
+		}
+		if i < len(segments) {
+			segments[i].Words = append(segments[i].Words, w)
+		}
+	}
+}
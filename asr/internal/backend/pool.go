@@ -0,0 +1,259 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"github.com/kxddry/lectura/asr/internal/config"
+	"github.com/kxddry/lectura/asr/internal/entities"
+	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrNoEndpoint is returned while every endpoint of a pool is unhealthy.
+var ErrNoEndpoint = errors.New("no healthy asr endpoint")
+
+var endpointVars = expvar.NewMap("asr_endpoints")
+
+// endpoint is a replica of the backend with its own concurrency limit.
+type endpoint struct {
+	url      string
+	backend  Backend
+	max      int
+	inFlight int
+	healthy  bool
+	failures int // consecutive failed requests and probes
+	passes   int // consecutive successful probes while unhealthy
+	latency  time.Duration
+	vars     *expvar.Map
+}
+
+// Pool spreads requests over several endpoints of the same backend type. Requests go to the least loaded
+// healthy endpoint and wait while all of them are busy. Endpoints are taken out after failing requests
+// or health probes, and put back once their probes pass again.
+type Pool struct {
+	log       *slog.Logger
+	opts      config.Health
+	endpoints []*endpoint
+
+	mu    sync.Mutex
+	freed chan struct{} // closed whenever a slot frees up or the health of an endpoint changes
+}
+
+func NewPool(log *slog.Logger, opts config.Health) *Pool {
+	return &Pool{log: log, opts: opts, freed: make(chan struct{})}
+}
+
+// Add registers an endpoint that takes up to maxConcurrency requests at a time.
+func (p *Pool) Add(url string, b Backend, maxConcurrency int) {
+	vars := new(expvar.Map).Init()
+	endpointVars.Set(url, vars)
+	vars.Set("healthy", expvar.Func(func() any {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for _, e := range p.endpoints {
+			if e.url == url {
+				return e.healthy
+			}
+		}
+		return false
+	}))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.endpoints = append(p.endpoints, &endpoint{url: url, backend: b, max: max(maxConcurrency, 1), healthy: true, vars: vars})
+}
+
+// Capacity returns the number of requests all endpoints take at a time.
+func (p *Pool) Capacity() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, e := range p.endpoints {
+		n += e.max
+	}
+	return n
+}
+
+func (p *Pool) Name() string { return p.endpoints[0].backend.Name() }
+
+func (p *Pool) Capabilities() Capabilities { return p.endpoints[0].backend.Capabilities() }
+
+// Health fails only if no endpoint is healthy.
+func (p *Pool) Health(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range p.endpoints {
+		if e.healthy {
+			return nil
+		}
+	}
+	return ErrNoEndpoint
+}
+
+func (p *Pool) Transcribe(ctx context.Context, req Request) (*entities.TranscribeResponse, error) {
+	e, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := e.backend.Transcribe(ctx, req)
+	p.release(ctx, e, time.Since(start), err)
+	return resp, err
+}
+
+// acquire reserves a slot on the healthy endpoint with the lowest share of its slots in use,
+// preferring the faster one on a tie.
+func (p *Pool) acquire(ctx context.Context) (*endpoint, error) {
+	for {
+		p.mu.Lock()
+		var best *endpoint
+		anyHealthy := false
+		for _, e := range p.endpoints {
+			if !e.healthy {
+				continue
+			}
+			anyHealthy = true
+			if e.inFlight >= e.max {
+				continue
+			}
+			if best == nil || e.inFlight*best.max < best.inFlight*e.max ||
+				(e.inFlight*best.max == best.inFlight*e.max && e.latency < best.latency) {
+				best = e
+			}
+		}
+		if best != nil {
+			best.inFlight++
+			best.vars.Add("in_flight", 1)
+			p.mu.Unlock()
+			return best, nil
+		}
+		freed := p.freed
+		p.mu.Unlock()
+
+		if !anyHealthy {
+			return nil, ErrNoEndpoint
+		}
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (p *Pool) release(ctx context.Context, e *endpoint, took time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e.inFlight--
+	e.vars.Add("in_flight", -1)
+	e.vars.Add("requests", 1)
+	log := p.log.With(slog.String("endpoint", e.url), slog.Duration("latency", took), slog.Int("in_flight", e.inFlight))
+
+	switch {
+	case err == nil:
+		e.failures = 0
+		e.observe(took)
+		log.Info("asr request done")
+	case ctx.Err() != nil:
+	case Retryable(err):
+		e.vars.Add("failures", 1)
+		log.Warn("asr request failed", sl.Err(err))
+		p.fail(e)
+	default:
+		// the endpoint answered, the request itself was bad
+		e.vars.Add("failures", 1)
+		e.failures = 0
+		log.Warn("asr request rejected", sl.Err(err))
+	}
+	p.broadcast()
+}
+
+// observe folds a successful request into the moving average latency of e; p.mu must be held.
+func (e *endpoint) observe(took time.Duration) {
+	if e.latency == 0 {
+		e.latency = took
+	} else {
+		e.latency = (e.latency*4 + took) / 5
+	}
+	e.vars.Set("latency_last_ms", expvarInt(took.Milliseconds()))
+	e.vars.Set("latency_avg_ms", expvarInt(e.latency.Milliseconds()))
+}
+
+// fail counts a failed request or probe and takes e out after UnhealthyAfter in a row; p.mu must be held.
+func (p *Pool) fail(e *endpoint) {
+	e.failures++
+	e.passes = 0
+	if e.healthy && e.failures >= p.opts.UnhealthyAfter {
+		e.healthy = false
+		p.log.Warn("asr endpoint is unhealthy, removing it", slog.String("endpoint", e.url), slog.Int("failures", e.failures))
+		p.broadcast()
+	}
+}
+
+// Run probes every endpoint each Interval until ctx is done. Unhealthy endpoints are put back
+// after HealthyAfter passed probes in a row.
+func (p *Pool) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, e := range p.endpoints {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.probe(ctx, e)
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Pool) probe(ctx context.Context, e *endpoint) {
+	pctx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+	defer cancel()
+	start := time.Now()
+	err := e.backend.Health(pctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.vars.Set("probe_ms", expvarInt(time.Since(start).Milliseconds()))
+	if err != nil {
+		p.log.Debug("asr endpoint probe failed", slog.String("endpoint", e.url), sl.Err(err))
+		p.fail(e)
+		return
+	}
+	e.failures = 0
+	if !e.healthy {
+		if e.passes++; e.passes >= p.opts.HealthyAfter {
+			e.healthy = true
+			e.passes = 0
+			p.log.Info("asr endpoint is healthy again, adding it back", slog.String("endpoint", e.url))
+			p.broadcast()
+		}
+	}
+}
+
+// broadcast wakes up the requests waiting for a slot; p.mu must be held.
+func (p *Pool) broadcast() {
+	close(p.freed)
+	p.freed = make(chan struct{})
+}
+
+func expvarInt(v int64) *expvar.Int {
+	i := new(expvar.Int)
+	i.Set(v)
+	return i
+}
@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"context"
+	"github.com/kxddry/lectura/asr/internal/entities"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// WhisperAPI is our FastAPI wrapper around openai-whisper, see asr/model.
+type WhisperAPI struct {
+	client         *http.Client
+	url            string
+	healthUrl      string
+	wordTimestamps bool
+	diarize        bool // the wrapper was started with HF_TOKEN, so it can label speakers
+}
+
+// NewWhisperAPI returns a client of the wrapper at apiUrl. An empty healthUrl probes /health next to it.
+func NewWhisperAPI(client *http.Client, apiUrl, healthUrl string, wordTimestamps, diarize bool) *WhisperAPI {
+	if healthUrl == "" {
+		healthUrl = strings.TrimSuffix(apiUrl, "/") + "/health"
+	}
+	return &WhisperAPI{client: client, url: apiUrl, healthUrl: healthUrl, wordTimestamps: wordTimestamps, diarize: diarize}
+}
+
+func (w *WhisperAPI) Name() string { return TypeWhisperAPI }
+
+func (w *WhisperAPI) Capabilities() Capabilities {
+	return Capabilities{Segments: true, Words: true, Language: true, Translate: true, Prompt: true, Speakers: w.diarize, Decoding: true}
+}
+
+func (w *WhisperAPI) Health(ctx context.Context) error {
+	return get(ctx, w.client, w.healthUrl, nil)
+}
+
+// Transcribe sends the audio to the wrapper. With word timestamps, the segments also carry word-level timings.
+func (w *WhisperAPI) Transcribe(ctx context.Context, req Request) (*entities.TranscribeResponse, error) {
+	u, err := url.Parse(w.url)
+	if err != nil {
+		return nil, err
+	}
+	if w.wordTimestamps {
+		q := u.Query()
+		q.Set("word_timestamps", "true")
+		u.RawQuery = q.Encode()
+	}
+
+	fields := url.Values{}
+	if req.Language != "" {
+		fields.Set("language", req.Language)
+	}
+	if req.Translate {
+		fields.Set("task", "translate")
+	}
+	if req.Prompt != "" {
+		fields.Set("initial_prompt", req.Prompt)
+	}
+	if req.Speakers {
+		fields.Set("diarize", "true")
+	}
+	if req.Temperature > 0 {
+		fields.Set("temperature", strconv.FormatFloat(req.Temperature, 'f', -1, 64))
+	}
+	if req.NoContext {
+		fields.Set("condition_on_previous_text", "false")
+	}
+
+	var result entities.TranscribeResponse
+	if err = post(ctx, w.client, u.String(), nil, fields, req.Audio, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
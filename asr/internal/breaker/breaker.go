@@ -0,0 +1,170 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	Closed   State = iota // requests flow
+	Open                  // the backend is unhealthy, requests wait
+	HalfOpen              // a single probe request decides whether to close or reopen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// Outcome is the result of a request, as far as the health of the backend is concerned.
+type Outcome int
+
+const (
+	Success  Outcome = iota // the backend answered, even if with a permanent error
+	Failure                 // the backend looks unhealthy: unreachable, timed out or 5xx
+	Canceled                // the request was abandoned and says nothing about the backend
+)
+
+// Breaker opens after Threshold consecutive failures and lets a probe through after OpenFor.
+// Instead of failing requests while open, it makes them wait, so the callers pause.
+type Breaker struct {
+	threshold int
+	openFor   time.Duration
+	onChange  func(from, to State)
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	until    time.Time     // end of the open state
+	probing  bool          // a half-open probe is in flight
+	changed  chan struct{} // closed on every state change
+}
+
+// New returns a closed breaker. onChange, if not nil, is called on every state change.
+func New(threshold int, openFor time.Duration, onChange func(from, to State)) *Breaker {
+	return &Breaker{
+		threshold: max(threshold, 1),
+		openFor:   openFor,
+		onChange:  onChange,
+		changed:   make(chan struct{}),
+	}
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Acquire blocks until a request may be sent, or ctx is done. done must be called with the outcome of the request.
+func (b *Breaker) Acquire(ctx context.Context) (done func(Outcome), err error) {
+	for {
+		b.mu.Lock()
+		if b.state == Open && !time.Now().Before(b.until) {
+			b.set(HalfOpen)
+		}
+		switch {
+		case b.state == Closed:
+			b.mu.Unlock()
+			return b.release(false), nil
+		case b.state == HalfOpen && !b.probing:
+			b.probing = true
+			b.mu.Unlock()
+			return b.release(true), nil
+		}
+		if err = b.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Wait blocks while the breaker is open, or until ctx is done.
+func (b *Breaker) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		if b.state != Open || !time.Now().Before(b.until) {
+			b.mu.Unlock()
+			return nil
+		}
+		if err := b.wait(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// wait unlocks b.mu and waits for a state change or the end of the open state.
+func (b *Breaker) wait(ctx context.Context) error {
+	changed, delay := b.changed, time.Until(b.until)
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		delay = b.openFor
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-changed:
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (b *Breaker) release(probe bool) func(Outcome) {
+	var once sync.Once
+	return func(o Outcome) {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			if probe {
+				b.probing = false
+			}
+			switch o {
+			case Success:
+				b.failures = 0
+				if b.state != Closed {
+					b.set(Closed)
+				}
+			case Failure:
+				b.failures++
+				if b.state == HalfOpen || (b.state == Closed && b.failures >= b.threshold) {
+					b.until = time.Now().Add(b.openFor)
+					b.set(Open)
+				}
+			case Canceled:
+				if probe {
+					// let the next request probe instead
+					b.broadcast()
+				}
+			}
+		})
+	}
+}
+
+// set changes the state; b.mu must be held.
+func (b *Breaker) set(s State) {
+	from := b.state
+	b.state = s
+	b.broadcast()
+	if b.onChange != nil {
+		b.onChange(from, s)
+	}
+}
+
+func (b *Breaker) broadcast() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
@@ -0,0 +1,270 @@
+package chunked
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kxddry/lectura/asr/internal/audio"
+	"github.com/kxddry/lectura/asr/internal/backend"
+	"github.com/kxddry/lectura/asr/internal/breaker"
+	"github.com/kxddry/lectura/asr/internal/config"
+	"github.com/kxddry/lectura/asr/internal/entities"
+	"github.com/kxddry/lectura/asr/internal/quality"
+	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Transcriber splits long recordings into overlapping windows, transcribes them concurrently
+// and stitches the results back together. The number of requests in flight is shared by all files.
+type Transcriber struct {
+	log     *slog.Logger
+	opts    config.Chunking
+	quality config.Quality
+	checker *quality.Checker
+	backend backend.Backend
+	breaker *breaker.Breaker
+	sem     chan struct{}
+}
+
+// New returns a Transcriber for b whose requests go through br. Backends without segments are chunked
+// without overlap, because there would be no timestamps to de-duplicate the overlapping speech with.
+// Windows that fail the quality checks q are re-transcribed.
+func New(log *slog.Logger, opts config.Chunking, q config.Quality, b backend.Backend, br *breaker.Breaker) (*Transcriber, error) {
+	const op = "chunked.New"
+	if opts.Window > 0 && opts.Overlap*2 >= opts.Window {
+		return nil, fmt.Errorf("%s: %w", op, errors.New("overlap must be less than half of the window"))
+	}
+	if !b.Capabilities().Segments {
+		opts.Overlap = 0
+	}
+	return &Transcriber{
+		log:     log,
+		opts:    opts,
+		quality: q,
+		checker: quality.New(q),
+		backend: b,
+		breaker: br,
+		sem:     make(chan struct{}, max(opts.Concurrency, 1)),
+	}, nil
+}
+
+// window is a piece of the audio, in seconds.
+type window struct {
+	start, end float64
+}
+
+// Name returns the name of the backend.
+func (t *Transcriber) Name() string { return t.backend.Name() }
+
+// Capabilities returns the capabilities of the backend.
+func (t *Transcriber) Capabilities() backend.Capabilities { return t.backend.Capabilities() }
+
+// Transcribe transcribes the audio file f with the same options for every window.
+// WAV files longer than a window are chunked, anything else is sent in a single request.
+// onProgress, if not nil, is called with the number of finished windows whenever one finishes.
+// The report lists the issues of the quality checks that were left after re-running the windows.
+func (t *Transcriber) Transcribe(ctx context.Context, f *os.File, opts backend.Options, onProgress func(done, total int)) (*entities.TranscribeResponse, quality.Report, error) {
+	const op = "chunked.Transcribe"
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, quality.Report{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	wav, err := audio.ParseWav(f, st.Size())
+	if err != nil {
+		if !errors.Is(err, audio.ErrNotWav) {
+			return nil, quality.Report{}, fmt.Errorf("%s: %w", op, err)
+		}
+		resp, issues, reruns, err := t.checked(ctx, opts, func() io.Reader { return io.NewSectionReader(f, 0, st.Size()) })
+		if err != nil {
+			return nil, quality.Report{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if onProgress != nil {
+			onProgress(1, 1)
+		}
+		alignSpeakers(nil, []*entities.TranscribeResponse{resp})
+		return resp, quality.Report{Issues: issues, Reruns: reruns}, nil
+	}
+
+	windows := t.split(wav.Duration())
+	results := make([]*entities.TranscribeResponse, len(windows))
+	issues := make([][]quality.Issue, len(windows))
+	var reruns atomic.Int64
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		finished int
+	)
+	for i, w := range windows {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, found, n, err := t.checked(ctx, opts, func() io.Reader { return wav.Window(w.start, w.end) })
+			if err != nil {
+				cancel(fmt.Errorf("chunk %d/%d: %w", i+1, len(windows), err))
+				return
+			}
+			results[i], issues[i] = resp, found
+			reruns.Add(int64(n))
+
+			if onProgress != nil {
+				mu.Lock()
+				finished++
+				onProgress(finished, len(windows))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err = context.Cause(ctx); err != nil {
+		return nil, quality.Report{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	report := quality.Report{Reruns: int(reruns.Load())}
+	for i, w := range windows {
+		// an issue in an overlap is reported by the window whose part of the overlap it is in, like its segments
+		from, to := bounds(windows, i)
+		for _, is := range issues[i] {
+			is.Start += w.start
+			is.End += w.start
+			if mid := (is.Start + is.End) / 2; mid >= from && mid < to {
+				report.Issues = append(report.Issues, is)
+			}
+		}
+	}
+
+	alignSpeakers(windows, results)
+	if len(windows) == 1 {
+		return results[0], report, nil
+	}
+	return stitch(windows, results), report, nil
+}
+
+// checked transcribes the audio returned by body and checks the transcript. While it has issues,
+// up to Reruns times, the audio is transcribed again with a higher temperature and without conditioning
+// on previous text. It returns the attempt with the fewest issues, and the number of re-runs.
+func (t *Transcriber) checked(ctx context.Context, opts backend.Options, body func() io.Reader) (*entities.TranscribeResponse, []quality.Issue, int, error) {
+	resp, err := t.transcribe(ctx, opts, body)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	issues := t.checker.Check(resp)
+	if !t.Capabilities().Decoding {
+		return resp, issues, 0, nil
+	}
+
+	reruns := 0
+	for reruns < t.quality.Reruns && len(issues) > 0 {
+		reruns++
+		rerun := opts
+		rerun.Temperature = min(opts.Temperature+t.quality.TemperatureStep*float64(reruns), 1)
+		rerun.NoContext = true
+		t.log.Info("transcript looks hallucinated, transcribing again",
+			slog.String("issue", issues[0].Kind), slog.String("detail", issues[0].Detail),
+			slog.Int("issues", len(issues)), slog.Float64("temperature", rerun.Temperature))
+
+		r, err := t.transcribe(ctx, rerun, body)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, 0, err
+			}
+			// keep the transcript we have
+			t.log.Warn("re-run failed", sl.Err(err))
+			break
+		}
+		if found := t.checker.Check(r); len(found) < len(issues) {
+			resp, issues = r, found
+		}
+	}
+	return resp, issues, reruns, nil
+}
+
+// split cuts duration seconds into windows that overlap by opts.Overlap.
+func (t *Transcriber) split(duration float64) []window {
+	size, overlap := t.opts.Window.Seconds(), t.opts.Overlap.Seconds()
+	if size <= 0 || duration <= size {
+		return []window{{0, duration}}
+	}
+
+	var out []window
+	for start := 0.0; ; start += size - overlap {
+		end := start + size
+		// don't leave a tail that is mostly overlap
+		if end+overlap >= duration {
+			return append(out, window{start, duration})
+		}
+		out = append(out, window{start, end})
+	}
+}
+
+// transcribe sends the audio returned by body, retrying retryable errors with an exponential,
+// jittered delay. body is called again for every attempt. While the breaker is open, it waits
+// without using up attempts.
+func (t *Transcriber) transcribe(ctx context.Context, opts backend.Options, body func() io.Reader) (*entities.TranscribeResponse, error) {
+	for attempt := 0; ; attempt++ {
+		done, err := t.breaker.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case t.sem <- struct{}{}:
+		case <-ctx.Done():
+			done(breaker.Canceled)
+			return nil, ctx.Err()
+		}
+		start := time.Now()
+		resp, err := t.backend.Transcribe(ctx, backend.Request{Audio: body(), Options: opts})
+		<-t.sem
+
+		switch {
+		case err == nil:
+			done(breaker.Success)
+			return resp, nil
+		case ctx.Err() != nil:
+			done(breaker.Canceled)
+			return nil, ctx.Err()
+		case !backend.Retryable(err):
+			done(breaker.Success)
+			return nil, err
+		}
+		done(breaker.Failure)
+
+		if attempt >= t.opts.Retries {
+			return nil, fmt.Errorf("%d attempts: %w", attempt+1, err)
+		}
+		delay := t.backoff(attempt)
+		t.log.Warn("transcription failed, retrying",
+			slog.Int("attempt", attempt+1), slog.Duration("took", time.Since(start)), slog.Duration("delay", delay), sl.Err(err))
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// backoff returns RetryDelay doubled for every attempt, capped at MaxRetryDelay,
+// and randomized between half and all of it, so windows that failed together do not retry together.
+func (t *Transcriber) backoff(attempt int) time.Duration {
+	d := t.opts.RetryDelay << min(attempt, 30)
+	if t.opts.MaxRetryDelay > 0 && (d > t.opts.MaxRetryDelay || d <= 0) {
+		d = t.opts.MaxRetryDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
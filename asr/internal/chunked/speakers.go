@@ -0,0 +1,69 @@
+package chunked
+
+import (
+	"fmt"
+	"github.com/kxddry/lectura/asr/internal/entities"
+	"sort"
+)
+
+// alignSpeakers renames the speakers of every window to labels of the whole file, "Speaker 1", "Speaker 2"...
+// in order of appearance. Backends label the speakers of each window on their own, so a label is matched to
+// the speaker of the previous window it shares the most speech with in their overlap.
+func alignSpeakers(windows []window, results []*entities.TranscribeResponse) {
+	next := 0
+	for i, r := range results {
+		names := make(map[string]string) // window label -> file label
+		if i > 0 {
+			matchSpeakers(names, windows[i-1], results[i-1], windows[i], r)
+		}
+		for j, s := range r.Segments {
+			if s.Speaker == "" {
+				continue
+			}
+			if _, ok := names[s.Speaker]; !ok {
+				next++
+				names[s.Speaker] = fmt.Sprintf("Speaker %d", next)
+			}
+			r.Segments[j].Speaker = names[s.Speaker]
+		}
+	}
+}
+
+// matchSpeakers maps the labels of cur to the file labels of prev, pairing those that overlap the longest first.
+func matchSpeakers(names map[string]string, pw window, prev *entities.TranscribeResponse, cw window, cur *entities.TranscribeResponse) {
+	type pair struct {
+		label, name string
+		overlap     float64
+	}
+	shared := make(map[[2]string]float64)
+	for _, s := range cur.Segments {
+		for _, p := range prev.Segments {
+			if s.Speaker == "" || p.Speaker == "" {
+				continue
+			}
+			if d := min(s.End+cw.start, p.End+pw.start) - max(s.Start+cw.start, p.Start+pw.start); d > 0 {
+				shared[[2]string{s.Speaker, p.Speaker}] += d
+			}
+		}
+	}
+
+	pairs := make([]pair, 0, len(shared))
+	for k, d := range shared {
+		pairs = append(pairs, pair{k[0], k[1], d})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].overlap != pairs[j].overlap {
+			return pairs[i].overlap > pairs[j].overlap
+		}
+		return pairs[i].label+pairs[i].name < pairs[j].label+pairs[j].name
+	})
+
+	taken := make(map[string]bool)
+	for _, p := range pairs {
+		if _, ok := names[p.label]; ok || taken[p.name] {
+			continue
+		}
+		names[p.label] = p.name
+		taken[p.name] = true
+	}
+}
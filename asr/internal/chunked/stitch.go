@@ -0,0 +1,90 @@
+package chunked
+
+import (
+	"github.com/kxddry/lectura/asr/internal/entities"
+	"strings"
+)
+
+// stitch merges the transcripts of overlapping windows. Every overlap is cut in the middle:
+// a segment belongs to the window that contains its midpoint on its side of the cut,
+// so speech in the overlap is kept once. A sentence on the cut that both windows recognized is kept once too;
+// repeated sentences anywhere else are real and kept. Timestamps are moved to the timeline of the whole file.
+func stitch(windows []window, results []*entities.TranscribeResponse) *entities.TranscribeResponse {
+	out := &entities.TranscribeResponse{Language: language(results)}
+	var texts []string
+	// the last kept segment, to drop a sentence on a cut that was recognized in both windows
+	var (
+		last    string
+		lastWin int
+		lastEnd float64
+	)
+
+	for i, w := range windows {
+		from, to := bounds(windows, i)
+
+		for _, s := range segments(w, results[i]) {
+			s.Start += w.start
+			s.End += w.start
+			if mid := (s.Start + s.End) / 2; mid < from || mid >= to {
+				continue
+			}
+
+			text := strings.TrimSpace(s.Text)
+			if text == "" || (lastWin == i-1 && lastEnd > w.start && s.Start < windows[lastWin].end && strings.EqualFold(text, last)) {
+				continue
+			}
+			last, lastWin, lastEnd = text, i, s.End
+
+			// keep the timeline monotonic where segments of two windows meet
+			if n := len(out.Segments); n > 0 && s.Start < out.Segments[n-1].End {
+				s.Start = min(out.Segments[n-1].End, s.End)
+			}
+			for j := range s.Words {
+				s.Words[j].Start += w.start
+				s.Words[j].End += w.start
+			}
+			s.ID = len(out.Segments)
+			out.Segments = append(out.Segments, s)
+			texts = append(texts, text)
+		}
+	}
+
+	out.Text = strings.Join(texts, " ")
+	return out
+}
+
+// bounds returns the part of the timeline window i owns: it ends in the middle of every overlap.
+func bounds(windows []window, i int) (from, to float64) {
+	from, to = windows[i].start, windows[i].end
+	if i > 0 {
+		from = (windows[i-1].end + windows[i].start) / 2
+	}
+	if i < len(windows)-1 {
+		to = (windows[i].end + windows[i+1].start) / 2
+	}
+	return from, to
+}
+
+// segments returns the segments of a window. A backend without segments gets one for the whole window.
+func segments(w window, resp *entities.TranscribeResponse) []entities.Segment {
+	if len(resp.Segments) > 0 {
+		return resp.Segments
+	}
+	return []entities.Segment{{Start: 0, End: w.end - w.start, Text: resp.Text}}
+}
+
+// language returns the language detected in most windows, preferring the earliest on a tie.
+func language(results []*entities.TranscribeResponse) string {
+	counts := make(map[string]int)
+	best := ""
+	for _, r := range results {
+		if r.Language == "" {
+			continue
+		}
+		counts[r.Language]++
+		if counts[r.Language] > counts[best] {
+			best = r.Language
+		}
+	}
+	return best
+}
@@ -0,0 +1,78 @@
+package chunked
+
+import (
+	"github.com/kxddry/lectura/asr/internal/entities"
+	"slices"
+	"testing"
+)
+
+func TestStitch(t *testing.T) {
+	seg := func(start, end float64, text string) entities.Segment {
+		return entities.Segment{Start: start, End: end, Text: text}
+	}
+	two := []window{{0, 30}, {25, 55}} // cut at 27.5
+
+	tests := []struct {
+		name    string
+		windows []window
+		results [][]entities.Segment // in window time
+		want    []string
+	}{
+		{
+			name:    "repeats in one window are kept",
+			windows: []window{{0, 30}},
+			results: [][]entities.Segment{{seg(1, 2, "Yes."), seg(2, 3, "Yes."), seg(4, 5, "Okay."), seg(5, 6, "okay.")}},
+			want:    []string{"Yes.", "Yes.", "Okay.", "okay."},
+		},
+		{
+			name:    "sentence on the cut recognized by both windows is kept once",
+			windows: two,
+			results: [][]entities.Segment{
+				{seg(20, 26, "Hello."), seg(26, 28.5, "Hello there.")},
+				{seg(2.8, 4.5, "Hello there."), seg(5, 8, "Next.")},
+			},
+			want: []string{"Hello.", "Hello there.", "Next."},
+		},
+		{
+			name:    "repeat after the overlap is kept",
+			windows: two,
+			results: [][]entities.Segment{
+				{seg(10, 11, "Okay.")},
+				{seg(15, 16, "Okay."), seg(17, 18, "Okay.")},
+			},
+			want: []string{"Okay.", "Okay.", "Okay."},
+		},
+		{
+			name:    "segments are split at the cut by their midpoint",
+			windows: two,
+			results: [][]entities.Segment{
+				{seg(20, 26, "First."), seg(28, 30, "Dropped.")},
+				{seg(0, 1, "Dropped too."), seg(3, 5, "Second.")},
+			},
+			want: []string{"First.", "Second."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := make([]*entities.TranscribeResponse, len(tt.results))
+			for i, segs := range tt.results {
+				results[i] = &entities.TranscribeResponse{Segments: slices.Clone(segs)}
+			}
+			out := stitch(tt.windows, results)
+
+			var got []string
+			for _, s := range out.Segments {
+				got = append(got, s.Text)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			for i := 1; i < len(out.Segments); i++ {
+				if out.Segments[i].Start < out.Segments[i-1].End {
+					t.Errorf("segment %d starts at %v before %v", i, out.Segments[i].Start, out.Segments[i-1].End)
+				}
+			}
+		})
+	}
+}
@@ -3,17 +3,80 @@ package config
 import (
 	"github.com/kxddry/lectura/shared/entities/config/kafka"
 	"github.com/kxddry/lectura/shared/entities/config/s3"
+	"time"
 )
 
 type Config struct {
 	Env        string           `yaml:"env" env-required:"true"`
-	WhisperAPI string           `yaml:"whisper_api" env-required:"true"`
+	WhisperAPI string           `yaml:"whisper_api"` // deprecated, use backend.endpoints
+	Backend    Backend          `yaml:"backend"`
 	S3Storage  s3.StorageConfig `yaml:"s3storage" env-required:"true"`
 	Kafka      Kafka            `yaml:"kafka" env-required:"true"`
+	Chunking   Chunking         `yaml:"chunking"`
+	Breaker    Breaker          `yaml:"breaker"`
+	Quality    Quality          `yaml:"quality"`
+	Metrics    string           `yaml:"metrics_addr" env-default:":9100"` // serves expvar at /debug/vars; empty disables it
+}
+
+// Backend selects the speech recognition service.
+type Backend struct {
+	Type           string        `yaml:"type" env-default:"whisper-api"` // whisper-api | openai | fake
+	URL            string        `yaml:"url"`                            // deprecated, a single endpoint; use endpoints
+	Endpoints      []Endpoint    `yaml:"endpoints"`
+	APIKey         string        `yaml:"api_key" env:"ASR_API_KEY"`
+	Model          string        `yaml:"model" env-default:"whisper-1"`       // openai only
+	WordTimestamps bool          `yaml:"word_timestamps" env-default:"false"` // word-level timings make asr.done messages several times larger
+	Diarize        bool          `yaml:"diarize" env-default:"false"`         // label speakers; whisper-api needs HF_TOKEN, openai a diarization model
+	Timeout        time.Duration `yaml:"timeout" env-default:"30m"`           // per request, i.e. per window
+	ConnectTimeout time.Duration `yaml:"connect_timeout" env-default:"10s"`
+	Health         Health        `yaml:"health"`
+}
+
+// Endpoint is a replica of the backend: a whisper-api endpoint, or the base URL of an OpenAI-compatible server.
+type Endpoint struct {
+	URL            string `yaml:"url"`
+	MaxConcurrency int    `yaml:"max_concurrency"` // requests at a time; 0 means 1
+	HealthURL      string `yaml:"health_url"`      // overrides the default probe of the backend type
+}
+
+// Health configures the health probes of the endpoints.
+type Health struct {
+	Interval       time.Duration `yaml:"interval" env-default:"15s"`
+	Timeout        time.Duration `yaml:"timeout" env-default:"5s"`
+	UnhealthyAfter int           `yaml:"unhealthy_after" env-default:"3"` // failed requests or probes in a row
+	HealthyAfter   int           `yaml:"healthy_after" env-default:"2"`   // passed probes in a row
+}
+
+// Chunking splits long recordings into overlapping windows that are transcribed in parallel.
+type Chunking struct {
+	Window        time.Duration `yaml:"window" env-default:"10m"` // 0 sends every file in one request
+	Overlap       time.Duration `yaml:"overlap" env-default:"10s"`
+	Concurrency   int           `yaml:"concurrency" env-default:"0"`  // requests in flight for all files together; 0 means the capacity of the endpoints
+	Retries       int           `yaml:"retries" env-default:"3"`      // per window, for retryable errors only
+	RetryDelay    time.Duration `yaml:"retry_delay" env-default:"5s"` // doubled after every failed attempt, with jitter
+	MaxRetryDelay time.Duration `yaml:"max_retry_delay" env-default:"2m"`
+}
+
+// Breaker stops sending requests, and consuming uploads, while the backend is unhealthy.
+type Breaker struct {
+	Threshold int           `yaml:"threshold" env-default:"5"`  // consecutive failures that open the breaker
+	OpenFor   time.Duration `yaml:"open_for" env-default:"30s"` // before a probe request is let through
+}
+
+// Quality flags segments that whisper likely hallucinated and re-transcribes their windows with other decoding settings.
+type Quality struct {
+	MaxRepeats        int           `yaml:"max_repeats" env-default:"4"` // a segment or phrase repeated this often in a row is a loop
+	MaxWordsPerSecond float64       `yaml:"max_words_per_second" env-default:"7"`
+	MinWordsPerSecond float64       `yaml:"min_words_per_second" env-default:"0.2"` // for segments of at least MinRateDuration
+	MinRateDuration   time.Duration `yaml:"min_rate_duration" env-default:"10s"`
+	MinAvgLogprob     float64       `yaml:"min_avg_logprob" env-default:"-1"`
+	Reruns            int           `yaml:"reruns" env-default:"2"`             // per window; 0 only reports the issues
+	TemperatureStep   float64       `yaml:"temperature_step" env-default:"0.2"` // added to the sampling temperature on every re-run
 }
 
 type Kafka struct {
-	Brokers []string           `yaml:"brokers" env-required:"true"`
-	Read    kafka.ReaderConfig `yaml:"read" env-required:"true"`
-	Write   kafka.WriterConfig `yaml:"write" env-required:"true"`
+	Brokers  []string           `yaml:"brokers" env-required:"true"`
+	Read     kafka.ReaderConfig `yaml:"read" env-required:"true"`
+	Write    kafka.WriterConfig `yaml:"write" env-required:"true"`
+	Progress kafka.WriterConfig `yaml:"progress" env-required:"true"` // file.progress
 }
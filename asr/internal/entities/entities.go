@@ -1,6 +1,26 @@
 package entities
 
 type TranscribeResponse struct {
-	Text     string `json:"text"`
-	Language string `json:"language"`
+	Text     string    `json:"text"`
+	Language string    `json:"language"`
+	Segments []Segment `json:"segments"`
+}
+
+// Segment is a whisper segment. Times are seconds from the start of the transcribed audio.
+type Segment struct {
+	ID    int     `json:"id"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+	Words []Word  `json:"words,omitempty"`
+	// Speaker labels who is speaking. Labels are only unique within a response.
+	Speaker string `json:"speaker,omitempty"`
+	// AvgLogprob is the average log probability of the tokens; nil if the backend does not report it
+	AvgLogprob *float64 `json:"avg_logprob,omitempty"`
+}
+
+type Word struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
 }
@@ -2,32 +2,151 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/kxddry/lectura/asr/internal/config"
-	"github.com/kxddry/lectura/asr/internal/whisper"
+	"github.com/kxddry/lectura/asr/internal/backend"
+	"github.com/kxddry/lectura/asr/internal/entities"
+	"github.com/kxddry/lectura/asr/internal/quality"
+	"github.com/kxddry/lectura/shared/entities/progress"
 	"github.com/kxddry/lectura/shared/entities/transcribed"
 	"github.com/kxddry/lectura/shared/entities/uploaded"
 	"github.com/kxddry/lectura/shared/utils/broker/kafka"
+	"github.com/kxddry/lectura/shared/utils/reporter"
 	"io"
+	"log/slog"
+	"os"
+	"strings"
 )
 
 type s3client interface {
 	Download(ctx context.Context, bucket string, key string) (io.ReadCloser, error)
 }
 
-func Pipeline(ctx context.Context, cfg config.Config, cli s3client, kp kafka.Pipeline[uploaded.Record, transcribed.Record], msg uploaded.Record) error {
-	file, err := cli.Download(ctx, msg.Bucket, msg.UUID+".wav")
+type Transcriber interface {
+	Name() string
+	Capabilities() backend.Capabilities
+	Transcribe(ctx context.Context, f *os.File, opts backend.Options, onProgress func(done, total int)) (*entities.TranscribeResponse, quality.Report, error)
+}
+
+func Pipeline(ctx context.Context, log *slog.Logger, tr Transcriber, cli s3client, kp kafka.Pipeline[uploaded.Record, transcribed.Record],
+	pw reporter.Writer, msg uploaded.Record) (err error) {
+	// the updater copies the results of the original recording
+	if msg.DuplicateOf != "" {
+		return nil
+	}
+
+	rep := reporter.Start(ctx, log, pw, msg.UUID, progress.ASR)
+	defer func() { rep.Finish(err) }()
+
+	file, err := download(ctx, cli, msg.Bucket, msg.AudioKey())
 	if err != nil {
-		return err
+		return kafka.Classify("s3", err)
 	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
 
-	resp, err := whisper.CallWhisperAPI(cfg.WhisperAPI, file)
-	if err != nil || resp.Text == "" {
-		return fmt.Errorf("callWhisperAPI: %w", err)
+	// the hints of the upload, as far as the backend supports them
+	opts := tr.Capabilities().Supported(backend.Options{
+		Language:  msg.Metadata.Language,
+		Translate: msg.Metadata.Translate,
+		Prompt:    msg.Metadata.InitialPrompt(),
+		Speakers:  true,
+	})
+
+	resp, report, err := tr.Transcribe(ctx, file, opts, rep.Progress)
+	if err != nil {
+		err = kafka.Classify("asr", fmt.Errorf("transcribe: %w", err))
+		// the backend rejected the request, it will reject it again
+		var se *backend.StatusError
+		if errors.As(err, &se) && !backend.Retryable(err) {
+			return kafka.Permanent(err)
+		}
+		return err
+	}
+	if resp.Text == "" {
+		return kafka.Permanent(kafka.Classify("asr", fmt.Errorf("transcribe: empty transcript")))
 	}
 
-	if err = kp.W.Write(ctx, transcribed.Record{UUID: msg.UUID, Text: resp.Text, Language: resp.Language}); err != nil {
-		return fmt.Errorf("upload text: %w", err)
+	if err = kp.W.Write(ctx, transcribed.Record{
+		UUID:     msg.UUID,
+		Text:     resp.Text,
+		Language: resp.Language,
+		Segments: segments(resp.Segments, msg.Preprocessing),
+		Settings: transcribed.Settings{
+			Backend:   tr.Name(),
+			Language:  opts.Language,
+			Translate: opts.Translate,
+			Prompt:    opts.Prompt,
+			Speakers:  opts.Speakers,
+		},
+		Quality: qualityReport(report, msg.Preprocessing),
+	}); err != nil {
+		return kafka.Classify("kafka", fmt.Errorf("upload text: %w", err))
 	}
 	return nil
 }
+
+// download copies the audio into a temporary file, so it can be read in windows.
+// The caller must close and remove the file.
+func download(ctx context.Context, cli s3client, bucket, key string) (*os.File, error) {
+	rc, err := cli.Download(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp("", "asr-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(tmp, rc); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// segments maps whisper segments to the timeline of the original recording, since the ASR audio
+// may have had silence cut out of it. Empty segments are dropped.
+func segments(in []entities.Segment, p *uploaded.Preprocessing) []transcribed.Segment {
+	out := make([]transcribed.Segment, 0, len(in))
+	for _, s := range in {
+		text := strings.TrimSpace(s.Text)
+		if text == "" {
+			continue
+		}
+		seg := transcribed.Segment{
+			Index:   len(out),
+			Start:   p.ToOriginal(s.Start),
+			End:     p.ToOriginal(s.End),
+			Text:    text,
+			Speaker: s.Speaker,
+		}
+		for _, w := range s.Words {
+			seg.Words = append(seg.Words, transcribed.Word{
+				Start: p.ToOriginal(w.Start),
+				End:   p.ToOriginal(w.End),
+				Text:  strings.TrimSpace(w.Word),
+			})
+		}
+		out = append(out, seg)
+	}
+	return out
+}
+
+// qualityReport maps the report of the quality checks to the timeline of the original recording.
+func qualityReport(r quality.Report, p *uploaded.Preprocessing) *transcribed.Quality {
+	out := &transcribed.Quality{Reruns: r.Reruns}
+	for _, is := range r.Issues {
+		out.Issues = append(out.Issues, transcribed.Issue{
+			Kind:   is.Kind,
+			Start:  p.ToOriginal(is.Start),
+			End:    p.ToOriginal(is.End),
+			Detail: is.Detail,
+		})
+	}
+	return out
+}
@@ -0,0 +1,143 @@
+package quality
+
+import (
+	"fmt"
+	"github.com/kxddry/lectura/asr/internal/config"
+	"github.com/kxddry/lectura/asr/internal/entities"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kinds of issues.
+const (
+	Repetition = "repetition"  // the same sentence or phrase over and over: a decoding loop
+	SpeechRate = "speech_rate" // too many or too few words for the duration of the segment
+	LowLogprob = "low_logprob" // the model was unsure of the text
+)
+
+// maxPhrase is the longest phrase, in words, that is looked for in repetition loops.
+const maxPhrase = 8
+
+// Issue is a suspicious segment. Times are seconds from the start of the checked audio.
+type Issue struct {
+	Kind       string
+	Start, End float64
+	Detail     string
+}
+
+// Checker flags segments that whisper likely hallucinated.
+type Checker struct {
+	opts config.Quality
+}
+
+func New(opts config.Quality) *Checker {
+	return &Checker{opts: opts}
+}
+
+// Check returns the issues of resp, in segment order.
+func (c *Checker) Check(resp *entities.TranscribeResponse) []Issue {
+	var out []Issue
+	cjk := isCJK(resp.Language)
+
+	run := 1 // segments in a row with the same text
+	for i, s := range resp.Segments {
+		text := normalize(s.Text)
+		if text == "" {
+			continue
+		}
+
+		if i > 0 && text == normalize(resp.Segments[i-1].Text) {
+			run++
+			if run == c.opts.MaxRepeats {
+				out = append(out, Issue{Kind: Repetition, Start: resp.Segments[i-run+1].Start, End: s.End,
+					Detail: fmt.Sprintf("segment repeated %d times: %q", run, strings.TrimSpace(s.Text))})
+			}
+		} else {
+			run = 1
+		}
+
+		if phrase, n := loop(strings.Fields(text), c.opts.MaxRepeats); n > 0 {
+			out = append(out, Issue{Kind: Repetition, Start: s.Start, End: s.End,
+				Detail: fmt.Sprintf("phrase repeated %d times: %q", n, phrase)})
+		}
+
+		duration := s.End - s.Start
+		rate := words(text, cjk) / max(duration, 0.5)
+		switch {
+		case c.opts.MaxWordsPerSecond > 0 && rate > c.opts.MaxWordsPerSecond:
+			out = append(out, Issue{Kind: SpeechRate, Start: s.Start, End: s.End,
+				Detail: fmt.Sprintf("%.1f words per second", rate)})
+		case duration >= c.opts.MinRateDuration.Seconds() && rate < c.opts.MinWordsPerSecond:
+			out = append(out, Issue{Kind: SpeechRate, Start: s.Start, End: s.End,
+				Detail: fmt.Sprintf("%.2f words per second over %.0fs", rate, duration)})
+		}
+
+		if s.AvgLogprob != nil && *s.AvgLogprob < c.opts.MinAvgLogprob {
+			out = append(out, Issue{Kind: LowLogprob, Start: s.Start, End: s.End,
+				Detail: fmt.Sprintf("average log probability %.2f", *s.AvgLogprob)})
+		}
+	}
+	return out
+}
+
+// loop finds a phrase of up to maxPhrase words that is repeated at least times in a row,
+// and returns it with the number of repetitions.
+func loop(words []string, times int) (string, int) {
+	if times < 2 {
+		return "", 0
+	}
+	for n := 1; n <= maxPhrase; n++ {
+		for i := 0; i+n*times <= len(words); i++ {
+			reps := 1
+			for j := i + n; j+n <= len(words) && equal(words[i:i+n], words[j:j+n]); j += n {
+				reps++
+			}
+			if reps >= times {
+				return strings.Join(words[i:i+n], " "), reps
+			}
+		}
+	}
+	return "", 0
+}
+
+func equal(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalize lowercases text and drops punctuation, so "Thank you." and "thank you" are the same.
+func normalize(text string) string {
+	return strings.Join(strings.Fields(strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) {
+			return ' '
+		}
+		return unicode.ToLower(r)
+	}, text)), " ")
+}
+
+// words counts the words of text. Languages written without spaces count two characters as a word.
+func words(text string, cjk bool) float64 {
+	if cjk {
+		return float64(utf8.RuneCountInString(strings.ReplaceAll(text, " ", ""))) / 2
+	}
+	return float64(len(strings.Fields(text)))
+}
+
+func isCJK(language string) bool {
+	switch language {
+	case "zh", "ja", "th", "lo", "km", "my", "bo":
+		return true
+	}
+	return false
+}
+
+// Report sums up the checks of a whole file.
+type Report struct {
+	Issues []Issue // left after the re-runs, in seconds from the start of the file
+	Reruns int     // re-transcribed windows, counting every attempt
+}
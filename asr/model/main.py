@@ -1,5 +1,6 @@
-from fastapi import FastAPI, File, UploadFile
+from fastapi import FastAPI, File, Form, UploadFile
 from pydantic import BaseModel
+from typing import List, Optional
 import whisper
 import tempfile
 import os
@@ -7,13 +8,52 @@ import os
 app = FastAPI(title="Whisper ASR Service")
 model = whisper.load_model(os.getenv("WHISPER_MODEL", "turbo"))
 
+# speaker diarization is optional: install pyannote.audio and set HF_TOKEN to enable it
+diarization = None
+if os.getenv("HF_TOKEN"):
+    from pyannote.audio import Pipeline
+    diarization = Pipeline.from_pretrained(
+        os.getenv("DIARIZATION_MODEL", "pyannote/speaker-diarization-3.1"),
+        use_auth_token=os.getenv("HF_TOKEN"),
+    )
+
+class Word(BaseModel):
+    word: str
+    start: float
+    end: float
+
+class Segment(BaseModel):
+    id: int
+    start: float
+    end: float
+    text: str
+    words: Optional[List[Word]] = None
+    speaker: Optional[str] = None
+    avg_logprob: Optional[float] = None
+    compression_ratio: Optional[float] = None
+    no_speech_prob: Optional[float] = None
+
 class TranscribeResponse(BaseModel):
     text: str
     language: str
+    segments: List[Segment] = []
+
+@app.get("/health")
+def health():
+    return {"status": "ok", "diarization": diarization is not None}
 
-@app.post("/", response_model=TranscribeResponse)
+@app.post("/", response_model=TranscribeResponse, response_model_exclude_none=True)
 
-def transcribe(file: UploadFile = File(...)):
+def transcribe(
+    file: UploadFile = File(...),
+    word_timestamps: bool = False,
+    language: Optional[str] = Form(None),  # ISO 639-1; detected if missing
+    task: str = Form("transcribe"),  # transcribe | translate (to English)
+    initial_prompt: Optional[str] = Form(None),
+    diarize: bool = Form(False),  # label segments with their speaker, if diarization is enabled
+    temperature: Optional[float] = Form(None),  # a single sampling temperature instead of the fallback schedule
+    condition_on_previous_text: bool = Form(True),  # false breaks repetition loops
+):
     suffix = os.path.splitext(file.filename)[1] or ".wav"
     print(f"Transcribing {file.filename} to {suffix}")
     with tempfile.NamedTemporaryFile(suffix=suffix, delete=True) as tmp:
@@ -22,12 +62,45 @@ def transcribe(file: UploadFile = File(...)):
         tmp.write(contents)
         tmp.flush()
         print(f"model transcribes {tmp.name}")
-        result = model.transcribe(tmp.name)
-        print(f"Transcription result: {result}")
+        result = model.transcribe(
+            tmp.name,
+            word_timestamps=word_timestamps,
+            language=language,
+            task=task,
+            initial_prompt=initial_prompt,
+            condition_on_previous_text=condition_on_previous_text,
+            **({"temperature": temperature} if temperature is not None else {}),
+        )
+        print(f"Transcription result: {result['text']}")
+        turns = []
+        if diarize and diarization is not None:
+            turns = [(t.start, t.end, label) for t, _, label in diarization(tmp.name).itertracks(yield_label=True)]
 
     return TranscribeResponse(
         text=result["text"],
         language=result["language"],
+        segments=[
+            Segment(
+                id=s["id"],
+                start=s["start"],
+                end=s["end"],
+                text=s["text"],
+                words=[Word(word=w["word"], start=w["start"], end=w["end"]) for w in s["words"]] if "words" in s else None,
+                speaker=speaker_of(s["start"], s["end"], turns),
+                avg_logprob=s.get("avg_logprob"),
+                compression_ratio=s.get("compression_ratio"),
+                no_speech_prob=s.get("no_speech_prob"),
+            )
+            for s in result["segments"]
+        ],
     )
 
 
+def speaker_of(start, end, turns):
+    """Returns the speaker who talks the longest between start and end, or None."""
+    talk = {}
+    for t_start, t_end, label in turns:
+        overlap = min(end, t_end) - max(start, t_start)
+        if overlap > 0:
+            talk[label] = talk.get(label, 0) + overlap
+    return max(talk, key=talk.get) if talk else None
@@ -0,0 +1,17 @@
+module github.com/kxddry/lectura/dlq
+
+go 1.24.4
+
+replace github.com/kxddry/lectura/shared => ../shared
+
+require (
+	github.com/kxddry/lectura/shared v0.0.0-00010101000000-000000000000
+	github.com/segmentio/kafka-go v0.4.48
+)
+
+require (
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	google.golang.org/protobuf v1.36.6 // indirect
+)
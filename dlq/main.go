@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	dlq "github.com/kxddry/lectura/shared/utils/broker/kafka"
+	"github.com/segmentio/kafka-go"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: dlq <list|inspect|replay> [flags]
+
+  list     lists the dead-lettered messages of a topic
+  inspect  prints the headers and the payload of the matching messages
+  replay   writes the matching messages back onto their source topic
+
+flags:
+`
+
+// filter selects dead-lettered messages; zero values match everything.
+type filter struct {
+	uuid      string
+	class     string
+	partition int
+	offset    int64
+}
+
+func (f filter) match(d dlq.DeadLetter) bool {
+	return (f.uuid == "" || d.UUID == f.uuid) &&
+		(f.class == "" || d.Class == f.class) &&
+		(f.partition < 0 || d.Message.Partition == f.partition) &&
+		(f.offset < 0 || d.Message.Offset == f.offset)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd := os.Args[1]
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+	brokers := fs.String("brokers", envOr("KAFKA_BROKERS", "localhost:9092"), "comma-separated kafka brokers")
+	topic := fs.String("topic", "", "source topic, e.g. file.uploaded, or its dead-letter topic (required)")
+	var f filter
+	fs.StringVar(&f.uuid, "uuid", "", "only messages about this file")
+	fs.StringVar(&f.class, "class", "", "only messages with this error class")
+	fs.IntVar(&f.partition, "partition", -1, "only messages of this partition of the dead-letter topic")
+	fs.Int64Var(&f.offset, "offset", -1, "only the message at this offset of the dead-letter topic")
+	limit := fs.Int("limit", 0, "stop after this many matching messages, 0 for all")
+	dryRun := fs.Bool("dry-run", false, "replay: only print what would be replayed")
+	all := fs.Bool("all", false, "replay: replay every message when no filter is given")
+	_ = fs.Parse(os.Args[2:])
+
+	if *topic == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !strings.HasSuffix(*topic, dlq.DLQSuffix) {
+		*topic += dlq.DLQSuffix
+	}
+	addrs := strings.Split(*brokers, ",")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var err error
+	switch cmd {
+	case "list":
+		err = list(ctx, addrs, *topic, f, *limit)
+	case "inspect":
+		err = inspect(ctx, addrs, *topic, f, *limit)
+	case "replay":
+		if f == (filter{partition: -1, offset: -1}) && !*all {
+			err = errors.New("refusing to replay the whole topic without -all")
+			break
+		}
+		err = replay(ctx, addrs, *topic, f, *limit, *dryRun)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dlq:", err)
+		os.Exit(1)
+	}
+}
+
+func list(ctx context.Context, brokers []string, topic string, f filter, limit int) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PARTITION\tOFFSET\tFAILED AT\tSERVICE\tCLASS\tATTEMPTS\tUUID\tERROR")
+	err := scan(ctx, brokers, topic, f, limit, func(d dlq.DeadLetter) error {
+		_, err := fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%d\t%s\t%s\n", d.Message.Partition, d.Message.Offset,
+			d.FailedAt.Local().Format(time.DateTime), d.Service, d.Class, d.Attempts, d.UUID, truncate(d.Error, 80))
+		return err
+	})
+	if ferr := tw.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+func inspect(ctx context.Context, brokers []string, topic string, f filter, limit int) error {
+	return scan(ctx, brokers, topic, f, limit, func(d dlq.DeadLetter) error {
+		fmt.Printf("%s/%d@%d\n", d.Message.Topic, d.Message.Partition, d.Message.Offset)
+		for _, h := range d.Message.Headers {
+			fmt.Printf("  %s: %s\n", h.Key, h.Value)
+		}
+		var v any
+		if err := json.Unmarshal(d.Message.Value, &v); err != nil {
+			fmt.Printf("%s\n\n", d.Message.Value)
+			return nil
+		}
+		out, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n\n", out)
+		return nil
+	})
+}
+
+func replay(ctx context.Context, brokers []string, topic string, f filter, limit int, dryRun bool) error {
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: 10 * time.Millisecond,
+	}
+	defer w.Close()
+
+	n := 0
+	err := scan(ctx, brokers, topic, f, limit, func(d dlq.DeadLetter) error {
+		fmt.Printf("%s/%d@%d -> %s (%s)\n", d.Message.Topic, d.Message.Partition, d.Message.Offset, d.SourceTopic, d.UUID)
+		if dryRun {
+			return nil
+		}
+		if err := w.WriteMessages(ctx, d.Replay()); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if !dryRun {
+		fmt.Printf("replayed %d messages\n", n)
+	}
+	return err
+}
+
+// idleTimeout is how long scan waits for the next message of a partition before it takes it as read.
+const idleTimeout = 5 * time.Second
+
+// scan calls fn with every message of the dead-letter topic that matches f, up to limit of them,
+// reading every partition from its first to its current last offset.
+func scan(ctx context.Context, brokers []string, topic string, f filter, limit int, fn func(dlq.DeadLetter) error) error {
+	conn, err := kafka.DialContext(ctx, "tcp", brokers[0])
+	if err != nil {
+		return err
+	}
+	partitions, err := conn.ReadPartitions(topic)
+	_ = conn.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", topic, err)
+	}
+
+	found := 0
+	for _, p := range partitions {
+		if f.partition >= 0 && p.ID != f.partition {
+			continue
+		}
+		first, last, err := offsets(ctx, brokers[0], topic, p.ID)
+		if err != nil {
+			return err
+		}
+		if f.offset >= 0 {
+			if f.offset < first || f.offset >= last {
+				continue
+			}
+			first, last = f.offset, f.offset+1
+		}
+		if first >= last {
+			continue
+		}
+
+		r := kafka.NewReader(kafka.ReaderConfig{Brokers: brokers, Topic: topic, Partition: p.ID, MaxBytes: 10e6})
+		if err = r.SetOffset(first); err != nil {
+			_ = r.Close()
+			return err
+		}
+		for {
+			// the last offset can be missing, e.g. a transaction marker, so a partition ends when it has nothing more
+			rctx, cancel := context.WithTimeout(ctx, idleTimeout)
+			m, err := r.ReadMessage(rctx)
+			cancel()
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				break
+			}
+			if err != nil {
+				_ = r.Close()
+				return err
+			}
+			if d := dlq.ParseDeadLetter(m); f.match(d) {
+				if err = fn(d); err != nil {
+					_ = r.Close()
+					return err
+				}
+				if found++; limit > 0 && found >= limit {
+					return r.Close()
+				}
+			}
+			if m.Offset+1 >= last {
+				break
+			}
+		}
+		if err = r.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offsets returns the first offset of a partition and the offset the next message will get.
+func offsets(ctx context.Context, broker, topic string, partition int) (first, last int64, err error) {
+	conn, err := kafka.DialLeader(ctx, "tcp", broker, topic, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	return conn.ReadOffsets()
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,7 @@
+-- Drop foreign key constraint first
+ALTER TABLE speakers DROP CONSTRAINT IF EXISTS fk_speakers_uuid;
+
+-- Drop table
+DROP TABLE IF EXISTS speakers;
+
+ALTER TABLE transcript_segments DROP COLUMN IF EXISTS speaker;
@@ -0,0 +1,13 @@
+-- Speaker labels of transcript segments, e.g. "Speaker 1"; NULL without diarization
+ALTER TABLE transcript_segments ADD COLUMN speaker TEXT;
+
+-- Create `speakers` table: names users gave to the speaker labels of a file
+CREATE TABLE speakers (
+                        uuid TEXT NOT NULL,
+                        label TEXT NOT NULL,
+                        name TEXT NOT NULL,
+                        PRIMARY KEY (uuid, label)
+);
+
+ALTER TABLE speakers
+    ADD CONSTRAINT fk_speakers_uuid FOREIGN KEY (uuid) REFERENCES files(uuid) ON DELETE CASCADE;
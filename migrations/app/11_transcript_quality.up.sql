@@ -0,0 +1,3 @@
+-- Report of the hallucination checks of the ASR: issues left after re-runs and the number of re-runs; NULL for legacy transcripts
+ALTER TABLE transcribed
+    ADD COLUMN quality JSONB;
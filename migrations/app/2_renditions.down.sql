@@ -0,0 +1,5 @@
+-- Drop foreign key constraint first
+ALTER TABLE renditions DROP CONSTRAINT IF EXISTS fk_renditions_uuid;
+
+-- Drop table
+DROP TABLE IF EXISTS renditions;
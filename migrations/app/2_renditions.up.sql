@@ -0,0 +1,14 @@
+-- Create `renditions` table: every object stored for a file (original, ASR WAV, playback copy)
+CREATE TABLE renditions (
+                            id SERIAL PRIMARY KEY,
+                            uuid TEXT NOT NULL,
+                            purpose TEXT NOT NULL,
+                            key TEXT NOT NULL,
+                            size BIGINT NOT NULL,
+                            checksum TEXT NOT NULL, -- hex SHA-256
+                            mime_type TEXT NOT NULL,
+                            UNIQUE (uuid, purpose)
+);
+
+ALTER TABLE renditions
+    ADD CONSTRAINT fk_renditions_uuid FOREIGN KEY (uuid) REFERENCES files(uuid) ON DELETE CASCADE;
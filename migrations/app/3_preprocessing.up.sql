@@ -0,0 +1,2 @@
+-- Filters applied to the ASR audio and the intervals they removed, see uploaded.Preprocessing
+ALTER TABLE files ADD COLUMN preprocessing JSONB;
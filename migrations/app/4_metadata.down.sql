@@ -0,0 +1,10 @@
+-- Drop indexes
+DROP INDEX IF EXISTS idx_files_tags;
+DROP INDEX IF EXISTS idx_files_course;
+
+ALTER TABLE files
+    DROP COLUMN IF EXISTS language,
+    DROP COLUMN IF EXISTS tags,
+    DROP COLUMN IF EXISTS lecture_date,
+    DROP COLUMN IF EXISTS course,
+    DROP COLUMN IF EXISTS title;
@@ -0,0 +1,10 @@
+-- Lecture metadata provided on upload, see uploaded.Metadata
+ALTER TABLE files
+    ADD COLUMN title TEXT CHECK (char_length(title) <= 200),
+    ADD COLUMN course TEXT CHECK (char_length(course) <= 100),
+    ADD COLUMN lecture_date DATE,
+    ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN language TEXT;
+
+CREATE INDEX idx_files_course ON files(user_id, course);
+CREATE INDEX idx_files_tags ON files USING GIN (tags);
@@ -0,0 +1,5 @@
+-- Drop foreign key constraint first
+ALTER TABLE frames DROP CONSTRAINT IF EXISTS fk_frames_uuid;
+
+-- Drop table
+DROP TABLE IF EXISTS frames;
@@ -0,0 +1,12 @@
+-- Create `frames` table: slide keyframes extracted from video lectures
+CREATE TABLE frames (
+                        id SERIAL PRIMARY KEY,
+                        uuid TEXT NOT NULL,
+                        idx INTEGER NOT NULL,
+                        timestamp DOUBLE PRECISION NOT NULL, -- seconds from the start of the recording
+                        key TEXT NOT NULL,
+                        UNIQUE (uuid, idx)
+);
+
+ALTER TABLE frames
+    ADD CONSTRAINT fk_frames_uuid FOREIGN KEY (uuid) REFERENCES files(uuid) ON DELETE CASCADE;
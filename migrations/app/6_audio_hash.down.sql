@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_files_audio_hash;
+
+ALTER TABLE files
+    DROP COLUMN IF EXISTS duplicate_of,
+    DROP COLUMN IF EXISTS audio_hash;
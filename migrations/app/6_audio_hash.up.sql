@@ -0,0 +1,6 @@
+-- SHA-256 of the ASR audio, used to reuse the results of an identical recording
+ALTER TABLE files
+    ADD COLUMN audio_hash TEXT,
+    ADD COLUMN duplicate_of TEXT; -- uuid of the file the results were copied from
+
+CREATE INDEX idx_files_audio_hash ON files(audio_hash);
@@ -0,0 +1,5 @@
+-- Drop foreign key constraint first
+ALTER TABLE transcript_segments DROP CONSTRAINT IF EXISTS fk_transcript_segments_uuid;
+
+-- Drop table
+DROP TABLE IF EXISTS transcript_segments;
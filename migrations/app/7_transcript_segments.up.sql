@@ -0,0 +1,14 @@
+-- Create `transcript_segments` table: timed pieces of a transcript
+CREATE TABLE transcript_segments (
+                        id SERIAL PRIMARY KEY,
+                        uuid TEXT NOT NULL,
+                        idx INTEGER NOT NULL,
+                        start_time DOUBLE PRECISION NOT NULL, -- seconds from the start of the recording
+                        end_time DOUBLE PRECISION NOT NULL,
+                        text TEXT NOT NULL,
+                        words JSONB, -- [{"start", "end", "text"}], NULL without word timestamps
+                        UNIQUE (uuid, idx)
+);
+
+ALTER TABLE transcript_segments
+    ADD CONSTRAINT fk_transcript_segments_uuid FOREIGN KEY (uuid) REFERENCES files(uuid) ON DELETE CASCADE;
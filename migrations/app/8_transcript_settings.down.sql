@@ -0,0 +1,2 @@
+ALTER TABLE transcribed
+    DROP COLUMN IF EXISTS settings;
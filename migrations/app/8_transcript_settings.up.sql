@@ -0,0 +1,3 @@
+-- ASR options a transcript was made with: backend, forced language, translation, initial prompt
+ALTER TABLE transcribed
+    ADD COLUMN settings JSONB NOT NULL DEFAULT '{}';
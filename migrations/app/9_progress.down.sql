@@ -0,0 +1,5 @@
+-- Drop foreign key constraint first
+ALTER TABLE progress DROP CONSTRAINT IF EXISTS fk_progress_uuid;
+
+-- Drop table
+DROP TABLE IF EXISTS progress;
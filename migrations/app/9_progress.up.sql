@@ -0,0 +1,13 @@
+-- Create `progress` table: the latest progress event of every file
+CREATE TABLE progress (
+                        uuid TEXT PRIMARY KEY,
+                        stage TEXT NOT NULL, -- asr | summarize
+                        status TEXT NOT NULL, -- started | running | done | failed
+                        percent DOUBLE PRECISION NOT NULL DEFAULT 0,
+                        eta DOUBLE PRECISION, -- estimated seconds until the stage is done, NULL if unknown
+                        error TEXT,
+                        updated_at TIMESTAMPTZ NOT NULL -- when the event was published
+);
+
+ALTER TABLE progress
+    ADD CONSTRAINT fk_progress_uuid FOREIGN KEY (uuid) REFERENCES files(uuid) ON DELETE CASCADE;
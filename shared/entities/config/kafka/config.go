@@ -8,9 +8,12 @@ type WriterConfig struct {
 	ClientID        string        `yaml:"client_id" env-required:"true"`
 	Retries         int           `yaml:"retries" env-default:"5"`
 	MaxMessageBytes int           `yaml:"max_message_bytes" env-default:"1048576"`
-	Acks            string        `yaml:"acks" env-default:"all"`        // 0 | 1 | all
-	Compression     string        `yaml:"compression" env-default:"lz4"` // lz4 | snappy | none | gzip | zstd
-	Timeout         time.Duration `yaml:"timeout" env-default:"5s"`      // time.Duration
+	Acks            string        `yaml:"acks" env-default:"all"`           // 0 | 1 | all
+	Compression     string        `yaml:"compression" env-default:"lz4"`    // lz4 | snappy | none | gzip | zstd
+	Codec           string        `yaml:"codec"`                            // json | protobuf; empty for the codec the type is registered with
+	Timeout         time.Duration `yaml:"timeout" env-default:"5s"`         // time.Duration
+	Async           bool          `yaml:"async" env-default:"false"`        // return from Write before delivery; failures go to the OnDelivery callback
+	BatchTimeout    time.Duration `yaml:"batch_timeout" env-default:"10ms"` // how long to wait for more messages to batch with
 }
 
 type ReaderConfig struct {
@@ -21,4 +24,9 @@ type ReaderConfig struct {
 	MaxBytes       int           `yaml:"max_bytes" env-default:"1048576"`   // 1MB
 	CommitInterval time.Duration `yaml:"commit_interval" env-default:"1s"`  // time.Duration, e.g. 1s
 	StartOffset    string        `yaml:"start_offset" env-default:"latest"` // earliest | latest
+	// redelivery of nacked messages
+	MaxAttempts     int           `yaml:"max_attempts" env-default:"5"`    // deliveries before a message is given up and skipped; 0 retries forever
+	RetryBackoff    time.Duration `yaml:"retry_backoff" env-default:"10s"` // before the first redelivery, doubled after every attempt
+	MaxRetryBackoff time.Duration `yaml:"max_retry_backoff" env-default:"5m"`
+	MaxWait         time.Duration `yaml:"max_wait" env-default:"1h"` // how long messages nacked with kafka.Early are redelivered, past max_attempts
 }
@@ -0,0 +1,39 @@
+package frames
+
+import (
+	"fmt"
+	"github.com/kxddry/lectura/shared/entities/manifest"
+	"github.com/kxddry/lectura/shared/utils/broker/kafka"
+)
+
+// EventType and SchemaVersion identify a Record in the envelope of a kafka message.
+const (
+	EventType     = "file.frames"
+	SchemaVersion = 1
+)
+
+func init() {
+	kafka.Register[Record](EventType, SchemaVersion, kafka.JSON)
+}
+
+// Record is published to frames.done once the slide keyframes of a video lecture are stored.
+type Record struct {
+	UUID   string  `json:"uuid"`
+	Bucket string  `json:"bucket"`
+	Frames []Frame `json:"frames"`
+}
+
+// PartitionKey returns the UUID of the lecture.
+func (r Record) PartitionKey() string { return r.UUID }
+
+// Frame is a single slide keyframe.
+type Frame struct {
+	Index     int     `json:"index"`     // 0-based, in timestamp order
+	Timestamp float64 `json:"timestamp"` // seconds from the start of the original recording
+	Key       string  `json:"key"`       // S3 key of the JPEG image
+}
+
+// Key returns where the frame with the given index is stored, next to the renditions of the lecture.
+func Key(uuid string, index int) string {
+	return manifest.ObjectKey(uuid, fmt.Sprintf("frames/%06d.jpg", index))
+}
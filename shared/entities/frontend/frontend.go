@@ -1,9 +1,36 @@
 package frontend
 
+import "github.com/kxddry/lectura/shared/entities/progress"
+
 type File struct {
-	UUID     string `json:"uuid,omitempty"`
-	Name     string `json:"name"`
-	URL      string `json:"url"`
-	MimeType string `json:"mime_type"`
-	Status   uint8  `json:"status"`
+	UUID        string `json:"uuid,omitempty"`
+	Name        string `json:"name"`
+	URL         string `json:"url"`          // playback rendition
+	DownloadURL string `json:"download_url"` // original file
+	MimeType    string `json:"mime_type"`
+	Status      uint8  `json:"status"`
+	// lecture metadata
+	Title       string   `json:"title,omitempty"`
+	Course      string   `json:"course,omitempty"`
+	LectureDate string   `json:"lecture_date,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Language    string   `json:"language,omitempty"`
+	// Progress is the latest progress event of the file; nil before processing started
+	Progress *progress.Record `json:"progress,omitempty"`
+	// Renditions maps a manifest.Purpose to its S3 key. It is used to presign URLs and never sent to the client.
+	Renditions map[string]string `json:"-"`
+}
+
+// Frame is a slide keyframe shown next to the transcript.
+type Frame struct {
+	Index     int     `json:"index"`
+	Timestamp float64 `json:"timestamp"` // seconds from the start of the recording
+	URL       string  `json:"url"`
+	Key       string  `json:"-"` // S3 key, presigned into URL
+}
+
+// Speaker is a speaker label of a transcript with the name the user gave it.
+type Speaker struct {
+	Label string `json:"label"` // e.g. "Speaker 1"
+	Name  string `json:"name"`  // the label until renamed
 }
@@ -0,0 +1,66 @@
+package manifest
+
+import "path"
+
+// Purpose tells what a rendition is used for.
+type Purpose = string
+
+const (
+	Original Purpose = "original" // the file exactly as uploaded
+	ASR      Purpose = "asr"      // 16 kHz mono WAV for transcription
+	Playback Purpose = "playback" // compressed copy for streaming in the browser
+)
+
+const fileName = "manifest.json"
+
+// Rendition is a single stored object derived from (or equal to) the uploaded file.
+type Rendition struct {
+	Purpose  Purpose `json:"purpose"`
+	Key      string  `json:"key"`
+	Size     int64   `json:"size"`
+	Checksum string  `json:"checksum"` // hex-encoded SHA-256
+	MimeType string  `json:"mime_type"`
+}
+
+// Manifest lists every rendition stored for a lecture. It is kept next to them, at Key(uuid).
+type Manifest struct {
+	UUID       string      `json:"uuid"`
+	Bucket     string      `json:"bucket"`
+	Renditions []Rendition `json:"renditions"`
+}
+
+// Get returns the rendition for the given purpose.
+func (m Manifest) Get(p Purpose) (Rendition, bool) {
+	for _, r := range m.Renditions {
+		if r.Purpose == p {
+			return r, true
+		}
+	}
+	return Rendition{}, false
+}
+
+// Prefix is the per-lecture S3 prefix every rendition is stored under.
+func Prefix(uuid string) string {
+	return uuid + "/"
+}
+
+// ObjectKey returns the key of a rendition named name, e.g. ObjectKey(uuid, "asr.wav").
+func ObjectKey(uuid, name string) string {
+	return path.Join(uuid, name)
+}
+
+// RenditionKey returns the key of the rendition for purpose p with the canonical extension ext of its format,
+// e.g. RenditionKey(uuid, Original, ".mp4") is "<uuid>/original.mp4".
+func RenditionKey(uuid string, p Purpose, ext string) string {
+	return ObjectKey(uuid, p+ext)
+}
+
+// Key returns the key of the manifest itself.
+func Key(uuid string) string {
+	return ObjectKey(uuid, fileName)
+}
+
+// LegacyKey is where files uploaded before renditions existed were stored: the ASR WAV only.
+func LegacyKey(uuid string) string {
+	return uuid + ".wav"
+}
@@ -0,0 +1,70 @@
+package progress
+
+import (
+	"fmt"
+	"github.com/kxddry/lectura/shared/utils/broker/kafka"
+	"math"
+	"time"
+)
+
+type Stage string
+
+const (
+	ASR       Stage = "asr"
+	Summarize Stage = "summarize"
+)
+
+type Status string
+
+const (
+	Started Status = "started"
+	Running Status = "running"
+	Done    Status = "done"
+	Failed  Status = "failed"
+)
+
+// EventType and SchemaVersion identify a Record in the envelope of a kafka message.
+const (
+	EventType     = "file.progress"
+	SchemaVersion = 1
+)
+
+func init() {
+	kafka.Register[Record](EventType, SchemaVersion, kafka.JSON)
+}
+
+// Record is published to file.progress while a file goes through a processing stage.
+// Only the latest record of a file is kept.
+type Record struct {
+	UUID    string    `json:"uuid"`
+	Stage   Stage     `json:"stage"`
+	Status  Status    `json:"status"`
+	Percent float64   `json:"percent"`         // of the stage, 0..100
+	ETA     float64   `json:"eta,omitempty"`   // estimated seconds until the stage is done; 0 if unknown
+	Error   string    `json:"error,omitempty"` // why the stage failed
+	At      time.Time `json:"at"`
+}
+
+// PartitionKey returns the UUID of the lecture.
+func (r Record) PartitionKey() string { return r.UUID }
+
+// String describes the record for students, e.g. "Transcribing: 42%, about 5 min left".
+func (r Record) String() string {
+	name, doing := "Transcription", "Transcribing"
+	if r.Stage == Summarize {
+		name, doing = "Summary", "Summarizing"
+	}
+	switch r.Status {
+	case Failed:
+		return name + " failed"
+	case Done:
+		return name + " finished"
+	case Started:
+		return doing + "..."
+	}
+	s := fmt.Sprintf("%s: %.0f%%", doing, r.Percent)
+	if r.ETA > 0 {
+		s += fmt.Sprintf(", about %d min left", int(math.Ceil(r.ETA/60)))
+	}
+	return s
+}
@@ -1,6 +1,21 @@
 package summarized
 
+import "github.com/kxddry/lectura/shared/utils/broker/kafka"
+
+// EventType and SchemaVersion identify a Record in the envelope of a kafka message.
+const (
+	EventType     = "file.summarized"
+	SchemaVersion = 1
+)
+
+func init() {
+	kafka.Register[Record](EventType, SchemaVersion, kafka.JSON)
+}
+
 type Record struct {
 	UUID string `json:"uuid"`
 	Text string `json:"text"`
 }
+
+// PartitionKey returns the UUID of the lecture.
+func (r Record) PartitionKey() string { return r.UUID }
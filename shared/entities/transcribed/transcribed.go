@@ -1,7 +1,102 @@
 package transcribed
 
+import (
+	"github.com/kxddry/lectura/shared/utils/broker/kafka"
+	"strings"
+)
+
+// EventType and SchemaVersion identify a Record in the envelope of a kafka message.
+const (
+	EventType     = "file.transcribed"
+	SchemaVersion = 1
+)
+
+func init() {
+	kafka.Register[Record](EventType, SchemaVersion, kafka.JSON)
+}
+
 type Record struct {
-	UUID     string `json:"uuid"`
-	Text     string `json:"text"`
-	Language string `json:"language"`
+	UUID     string    `json:"uuid"`
+	Text     string    `json:"text"`
+	Language string    `json:"language"`
+	Segments []Segment `json:"segments,omitempty"` // empty for legacy records
+	Settings Settings  `json:"settings"`
+	Quality  *Quality  `json:"quality,omitempty"` // nil for legacy records
+}
+
+// PartitionKey returns the UUID of the lecture.
+func (r Record) PartitionKey() string { return r.UUID }
+
+// Quality is the report of the checks the ASR runs against hallucinations.
+type Quality struct {
+	Issues []Issue `json:"issues,omitempty"` // left after re-transcribing the windows they were found in
+	Reruns int     `json:"reruns,omitempty"` // windows transcribed again
+}
+
+// Issue is a suspicious part of the transcript: a repetition loop, an implausible speech rate
+// or a low average log probability.
+type Issue struct {
+	Kind   string  `json:"kind"` // repetition | speech_rate | low_logprob
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+	Detail string  `json:"detail"`
+}
+
+// Settings are the ASR options the transcript was actually made with.
+type Settings struct {
+	Backend   string `json:"backend,omitempty"`
+	Language  string `json:"language,omitempty"`  // forced spoken language; empty if it was detected
+	Translate bool   `json:"translate,omitempty"` // the text was translated to English
+	Prompt    string `json:"prompt,omitempty"`    // initial prompt
+	Speakers  bool   `json:"speakers,omitempty"`  // segments are labeled with their speaker
+}
+
+// Segment is a timed piece of the transcript. Times are seconds from the start of the original recording.
+type Segment struct {
+	Index int     `json:"index"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+	Words []Word  `json:"words,omitempty"` // only if word timestamps are enabled in the ASR
+	// Speaker labels who is speaking, e.g. "Speaker 1"; empty without diarization
+	Speaker string `json:"speaker,omitempty"`
+}
+
+// Word is a single timed word of a segment.
+type Word struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// Attributed returns the text with every turn prefixed by its speaker, e.g. "Speaker 1: ...",
+// a turn per paragraph. Without speaker labels it returns the plain text.
+func (r Record) Attributed() string {
+	if !r.HasSpeakers() {
+		return r.Text
+	}
+	var b strings.Builder
+	last := ""
+	for _, s := range r.Segments {
+		// an unlabeled segment continues the current turn
+		if s.Speaker != "" && s.Speaker != last {
+			if b.Len() > 0 {
+				b.WriteString("\n\n")
+			}
+			b.WriteString(s.Speaker + ":")
+			last = s.Speaker
+		}
+		b.WriteString(" " + strings.TrimSpace(s.Text))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// HasSpeakers reports whether any segment is labeled with its speaker.
+func (r Record) HasSpeakers() bool {
+	for _, s := range r.Segments {
+		if s.Speaker != "" {
+			return true
+		}
+	}
+	return false
 }
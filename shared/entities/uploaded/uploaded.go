@@ -1,42 +1,108 @@
 package uploaded
 
-import "io"
+import (
+	"github.com/kxddry/lectura/shared/entities/manifest"
+	"github.com/kxddry/lectura/shared/utils/broker/kafka"
+	"io"
+	"strings"
+)
+
+// EventType and SchemaVersion identify a Record in the envelope of a kafka message.
+const (
+	EventType     = "file.uploaded"
+	SchemaVersion = 1
+)
+
+func init() {
+	kafka.Register[Record](EventType, SchemaVersion, kafka.JSON)
+}
 
 type Record struct {
 	UUID   string `json:"uuid"`
 	Bucket string `json:"bucket"`
+	Key    string `json:"key,omitempty"` // ASR rendition; empty for legacy records, see manifest.LegacyKey
+	// Preprocessing is what was done to the ASR rendition; nil if it is a plain conversion.
+	Preprocessing *Preprocessing `json:"preprocessing,omitempty"`
+	Metadata      Metadata       `json:"metadata"`
+	AudioHash     string         `json:"audio_hash,omitempty"` // hex SHA-256 of the ASR audio
+	// DuplicateOf is the UUID of a processed file with the same AudioHash. Its results are reused,
+	// so the record must not be transcribed or summarized again.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
 	// Update struct should only be used by the Updater microservice.
 	Update struct {
-		UserID      uint   `json:"user_id"`      // 1337
-		OGFileName  string `json:"og_file_name"` // "example"
-		OGExtension string `json:"og_extension"` // .ogg, .wav, .mp4, .mp3, etc.
-		Status      int    `json:"status"`       // default = 0 (uploaded); 1 - transcribed; 2 - summarized (ready)
+		UserID      uint                 `json:"user_id"`              // 1337
+		OGFileName  string               `json:"og_file_name"`         // "example"
+		OGExtension string               `json:"og_extension"`         // .ogg, .wav, .mp4, .mp3, etc.
+		Status      int                  `json:"status"`               // default = 0 (uploaded); 1 - transcribed; 2 - summarized (ready)
+		Renditions  []manifest.Rendition `json:"renditions,omitempty"` // copy of the manifest entries
 	} `json:"update"`
 }
 
+// PartitionKey returns the UUID of the lecture.
+func (r Record) PartitionKey() string { return r.UUID }
+
+// AudioKey returns the S3 key of the audio that should be transcribed.
+func (r Record) AudioKey() string {
+	if r.Key != "" {
+		return r.Key
+	}
+	return manifest.LegacyKey(r.UUID)
+}
+
+// Metadata is what the student told us about the lecture. Every field is optional.
+type Metadata struct {
+	Title       string   `json:"title,omitempty"`
+	Course      string   `json:"course,omitempty"`
+	LectureDate string   `json:"lecture_date,omitempty"` // YYYY-MM-DD
+	Tags        []string `json:"tags,omitempty"`
+	Language    string   `json:"language,omitempty"` // spoken language hint, ISO 639-1
+	// transcription hints
+	Translate  bool     `json:"translate,omitempty"`  // translate the transcript to English
+	Prompt     string   `json:"prompt,omitempty"`     // initial prompt, e.g. a sentence in the style of the lecture
+	Vocabulary []string `json:"vocabulary,omitempty"` // course-specific terms the ASR should spell correctly
+}
+
+// InitialPrompt combines the prompt and the vocabulary into the prompt given to the ASR.
+func (m Metadata) InitialPrompt() string {
+	p := m.Prompt
+	if len(m.Vocabulary) > 0 {
+		if p != "" {
+			p += " "
+		}
+		p += "Glossary: " + strings.Join(m.Vocabulary, ", ") + "."
+	}
+	return p
+}
+
+// Preprocessing describes the filters applied to the audio before transcription.
+type Preprocessing struct {
+	Steps []string `json:"steps"`          // e.g. "trim_silence", "remove_pauses", "denoise", "loudnorm"
+	Cuts  []Cut    `json:"cuts,omitempty"` // removed intervals on the original timeline, sorted
+}
+
+// Cut is an interval, in seconds of the original recording, that was removed from the audio.
+type Cut struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// ToOriginal maps a timestamp of the preprocessed audio back to the original recording.
+func (p *Preprocessing) ToOriginal(t float64) float64 {
+	if p == nil {
+		return t
+	}
+	for _, c := range p.Cuts {
+		if t < c.Start {
+			break
+		}
+		t += c.End - c.Start
+	}
+	return t
+}
+
 type File interface {
 	FullName() string
 	Data() io.Reader
 	Size() int64
 	MimeType() string
 }
-
-var AllowedMimeTypes = map[string]string{
-	"video/mp4":       ".mp4",
-	"video/quicktime": ".mov",
-	"video/x-msvideo": ".avi",
-	"audio/aac":       ".aac",
-	"audio/wav":       ".wav",
-	"audio/ogg":       ".ogg",
-	"audio/mpeg":      ".mp3",
-}
-
-var Extensions = map[string]string{
-	".mp4": "video/mp4",
-	".mov": "video/quicktime",
-	".avi": "video/x-msvideo",
-	".aac": "audio/aac",
-	".wav": "audio/wav",
-	".ogg": "audio/ogg",
-	".mp3": "audio/mpeg",
-}
@@ -0,0 +1,106 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+	"sync"
+)
+
+// HeaderContentType names the codec of the payload; messages without it use the codec their type is registered with.
+const HeaderContentType = "content-type"
+
+// Codec encodes the payload of messages.
+type Codec interface {
+	Name() string        // as in the writer config, e.g. "json"
+	ContentType() string // as in the content-type header
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSON encodes records with encoding/json.
+	JSON Codec = jsonCodec{}
+	// Protobuf encodes records that are protobuf messages, i.e. generated *pb.X types.
+	Protobuf Codec = protobufCodec{}
+)
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// TypeChecker is implemented by codecs that encode only some types, so a writer can refuse a type up front.
+type TypeChecker interface {
+	CanEncode(t reflect.Type) error
+}
+
+var codecs = struct {
+	sync.RWMutex
+	byName, byContentType map[string]Codec
+}{byName: map[string]Codec{}, byContentType: map[string]Codec{}}
+
+func init() {
+	RegisterCodec(JSON)
+	RegisterCodec(Protobuf)
+}
+
+// RegisterCodec makes c available by its name and content type.
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.byName[c.Name()] = c
+	codecs.byContentType[c.ContentType()] = c
+}
+
+// CodecByName returns the registered codec called name.
+func CodecByName(name string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownCodec, name)
+	}
+	return c, nil
+}
+
+func codecByContentType(contentType string) (Codec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.byContentType[contentType]
+	return c, ok
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                       { return "json" }
+func (jsonCodec) ContentType() string                { return "application/json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string        { return "protobuf" }
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protobufCodec) CanEncode(t reflect.Type) error {
+	if !t.Implements(reflect.TypeFor[proto.Message]()) {
+		return fmt.Errorf("protobuf: %v is not a proto.Message", t)
+	}
+	return nil
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -0,0 +1,235 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
+	"github.com/segmentio/kafka-go"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DLQSuffix is appended to a topic to name its dead-letter topic.
+const DLQSuffix = ".dlq"
+
+// Headers of dead-lettered messages, next to the original headers.
+const (
+	HeaderError           = "dlq-error"
+	HeaderErrorClass      = "dlq-error-class"
+	HeaderAttempts        = "dlq-attempts"
+	HeaderService         = "dlq-service"
+	HeaderSourceTopic     = "dlq-source-topic"
+	HeaderSourcePartition = "dlq-source-partition"
+	HeaderSourceOffset    = "dlq-source-offset"
+	HeaderProducedAt      = "dlq-produced-at"      // when the original message was produced
+	HeaderFirstAttemptAt  = "dlq-first-attempt-at" // when the service first received it
+	HeaderFailedAt        = "dlq-failed-at"        // when the last attempt failed
+)
+
+// TimeFormat is the format of the timestamp headers.
+const TimeFormat = time.RFC3339Nano
+
+// DeadLetters publishes the messages a service gave up on to the dead-letter topics of their source topics.
+type DeadLetters struct {
+	w       *kafka.Writer
+	service string
+}
+
+// NewDeadLetters returns a publisher for service. Writes are synchronous, so a message is only
+// committed on its source topic once it is stored in the dead-letter topic.
+func NewDeadLetters(brokers []string, service string) *DeadLetters {
+	return &DeadLetters{
+		w: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			BatchTimeout:           10 * time.Millisecond,
+			AllowAutoTopicCreation: false,
+		},
+		service: service,
+	}
+}
+
+// Publish stores m, which failed attempts times with err, in the dead-letter topic of its topic.
+func (d *DeadLetters) Publish(ctx context.Context, m kafka.Message, err error, attempts int, firstAttempt time.Time) error {
+	const op = "kafka.DeadLetters.Publish"
+
+	headers := make([]kafka.Header, 0, len(m.Headers)+10)
+	for _, h := range m.Headers {
+		// a replayed message that failed again gets fresh failure headers
+		if !strings.HasPrefix(h.Key, "dlq-") {
+			headers = append(headers, h)
+		}
+	}
+	headers = append(headers,
+		kafka.Header{Key: HeaderError, Value: []byte(err.Error())},
+		kafka.Header{Key: HeaderErrorClass, Value: []byte(ErrorClass(err))},
+		kafka.Header{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+		kafka.Header{Key: HeaderService, Value: []byte(d.service)},
+		kafka.Header{Key: HeaderSourceTopic, Value: []byte(m.Topic)},
+		kafka.Header{Key: HeaderSourcePartition, Value: []byte(strconv.Itoa(m.Partition))},
+		kafka.Header{Key: HeaderSourceOffset, Value: []byte(strconv.FormatInt(m.Offset, 10))},
+		kafka.Header{Key: HeaderProducedAt, Value: []byte(m.Time.UTC().Format(TimeFormat))},
+		kafka.Header{Key: HeaderFirstAttemptAt, Value: []byte(firstAttempt.UTC().Format(TimeFormat))},
+		kafka.Header{Key: HeaderFailedAt, Value: []byte(time.Now().UTC().Format(TimeFormat))},
+	)
+
+	if err = d.w.WriteMessages(ctx, kafka.Message{
+		Topic:   m.Topic + DLQSuffix,
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+	}); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// ToDeadLetters returns a give-up hook for Reader.OnGiveUp that publishes to d and logs the message.
+func ToDeadLetters[T any](log *slog.Logger, d *DeadLetters) func(m Message[T], err error) error {
+	return func(m Message[T], err error) error {
+		log := log.With(slog.String("UUID", UUIDOf(m.Raw)), slog.String("topic", m.Raw.Topic),
+			slog.Int("partition", m.Partition), slog.Int64("offset", m.Offset), slog.Int("attempts", m.Attempt), sl.Err(err))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if perr := d.Publish(ctx, m.Raw, err, m.Attempt, m.Received); perr != nil {
+			log.Error("failed to dead-letter message, it will be retried", slog.String("dlq_error", perr.Error()))
+			return perr
+		}
+		log.Error("giving up on message, moved it to the dead-letter topic", slog.String("dlq", m.Raw.Topic+DLQSuffix))
+		return nil
+	}
+}
+
+// InvalidToDeadLetters returns a hook for Reader.OnInvalid that publishes to d and logs the message.
+func InvalidToDeadLetters(log *slog.Logger, d *DeadLetters) func(m kafka.Message, err error) error {
+	return func(m kafka.Message, err error) error {
+		log := log.With(slog.String("UUID", UUIDOf(m)), slog.String("topic", m.Topic), slog.Int("partition", m.Partition), slog.Int64("offset", m.Offset), sl.Err(err))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if perr := d.Publish(ctx, m, err, 1, time.Now()); perr != nil {
+			log.Error("failed to dead-letter invalid message", slog.String("dlq_error", perr.Error()))
+			return perr
+		}
+		log.Error("invalid message moved to the dead-letter topic", slog.String("dlq", m.Topic+DLQSuffix))
+		return nil
+	}
+}
+
+// UUIDOf returns the UUID of the file a message is about, or "" if it has none. Records are keyed by it;
+// messages without a key, written before that, are looked up in a JSON payload.
+func UUIDOf(m kafka.Message) string {
+	if len(m.Key) > 0 {
+		return string(m.Key)
+	}
+	var r struct {
+		UUID string `json:"uuid"`
+	}
+	_ = json.Unmarshal(m.Value, &r)
+	return r.UUID
+}
+
+// ClassError gives an error a class, which dead-lettered messages can be filtered by.
+type ClassError struct {
+	Class string
+	Err   error
+}
+
+func (e *ClassError) Error() string { return e.Err.Error() }
+
+func (e *ClassError) Unwrap() error { return e.Err }
+
+// Classify returns err with class, or nil if err is nil.
+func Classify(class string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ClassError{Class: class, Err: err}
+}
+
+// ErrorClass returns the class of err: the outermost class given with Classify, "invalid", "timeout" or "network",
+// or else the operation that failed, which is the first part of the error message.
+func ErrorClass(err error) string {
+	var ce *ClassError
+	var ne net.Error
+	switch {
+	case errors.As(err, &ce):
+		return ce.Class
+	case errors.Is(err, ErrInvalidMessage):
+		return "invalid"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &ne):
+		return "network"
+	}
+	op, _, _ := strings.Cut(err.Error(), ":")
+	return strings.TrimSpace(op)
+}
+
+// Close flushes and closes the writer.
+func (d *DeadLetters) Close() error {
+	return d.w.Close()
+}
+
+// DeadLetter is a message read back from a dead-letter topic.
+type DeadLetter struct {
+	Message      kafka.Message
+	UUID         string
+	Error        string
+	Class        string
+	Attempts     int
+	Service      string
+	SourceTopic  string
+	Partition    int   // of the source topic
+	Offset       int64 // in the source topic
+	ProducedAt   time.Time
+	FirstAttempt time.Time
+	FailedAt     time.Time
+}
+
+// ParseDeadLetter reads the headers of m, a message of a dead-letter topic.
+func ParseDeadLetter(m kafka.Message) DeadLetter {
+	d := DeadLetter{Message: m, UUID: UUIDOf(m), SourceTopic: strings.TrimSuffix(m.Topic, DLQSuffix)}
+	for _, h := range m.Headers {
+		v := string(h.Value)
+		switch h.Key {
+		case HeaderError:
+			d.Error = v
+		case HeaderErrorClass:
+			d.Class = v
+		case HeaderAttempts:
+			d.Attempts, _ = strconv.Atoi(v)
+		case HeaderService:
+			d.Service = v
+		case HeaderSourceTopic:
+			d.SourceTopic = v
+		case HeaderSourcePartition:
+			d.Partition, _ = strconv.Atoi(v)
+		case HeaderSourceOffset:
+			d.Offset, _ = strconv.ParseInt(v, 10, 64)
+		case HeaderProducedAt:
+			d.ProducedAt, _ = time.Parse(TimeFormat, v)
+		case HeaderFirstAttemptAt:
+			d.FirstAttempt, _ = time.Parse(TimeFormat, v)
+		case HeaderFailedAt:
+			d.FailedAt, _ = time.Parse(TimeFormat, v)
+		}
+	}
+	return d
+}
+
+// Replay returns the original message, to be written back onto its source topic.
+func (d DeadLetter) Replay() kafka.Message {
+	var headers []kafka.Header
+	for _, h := range d.Message.Headers {
+		if !strings.HasPrefix(h.Key, "dlq-") {
+			headers = append(headers, h)
+		}
+	}
+	return kafka.Message{Topic: d.SourceTopic, Key: d.Message.Key, Value: d.Message.Value, Headers: headers}
+}
@@ -0,0 +1,26 @@
+package kafka
+
+import (
+	"github.com/segmentio/kafka-go"
+	"testing"
+)
+
+func TestUUIDOf(t *testing.T) {
+	tests := []struct {
+		name string
+		m    kafka.Message
+		want string
+	}{
+		{"key", kafka.Message{Key: []byte("a"), Value: []byte(`{"uuid":"b"}`)}, "a"},
+		{"binary payload", kafka.Message{Key: []byte("a"), Value: []byte{0x0a, 0x01, 'b'}}, "a"},
+		{"json payload without a key", kafka.Message{Value: []byte(`{"uuid":"b"}`)}, "b"},
+		{"neither", kafka.Message{Value: []byte{0x0a, 0x01, 'b'}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UUIDOf(tt.m); got != tt.want {
+				t.Fatalf("UUIDOf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
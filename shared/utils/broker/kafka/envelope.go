@@ -0,0 +1,115 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"strconv"
+	"time"
+)
+
+// Headers of the envelope every message is written with.
+const (
+	HeaderEventID       = "event-id"
+	HeaderEventType     = "event-type"
+	HeaderSchemaVersion = "schema-version"
+	HeaderOccurredAt    = "occurred-at"
+	HeaderProducer      = "producer"
+	HeaderCorrelationID = "correlation-id"
+)
+
+// ErrInvalidMessage is wrapped by the errors of messages a Reader cannot deliver.
+var ErrInvalidMessage = errors.New("invalid message")
+
+var (
+	ErrUnknownType        = fmt.Errorf("%w: unknown event type", ErrInvalidMessage)
+	ErrUnsupportedVersion = fmt.Errorf("%w: unsupported schema version", ErrInvalidMessage)
+	ErrBadEnvelope        = fmt.Errorf("%w: bad envelope", ErrInvalidMessage)
+	ErrDecode             = fmt.Errorf("%w: cannot decode", ErrInvalidMessage)
+)
+
+// Envelope describes the event a message carries. It is stored in the message headers, so the payload
+// is the bare record.
+type Envelope struct {
+	EventID       string
+	Type          string
+	SchemaVersion int
+	OccurredAt    time.Time
+	Producer      string
+	// CorrelationID is shared by all the events that follow from the same upload.
+	CorrelationID string
+}
+
+// NewEnvelope returns the envelope of a new event of type typ. The correlation id is taken from ctx;
+// without one, the event starts a new chain and is correlated with itself.
+func NewEnvelope(ctx context.Context, typ string, version int, producer string) Envelope {
+	id := uuid.NewString()
+	correlationID, _ := ctx.Value(correlationKey{}).(string)
+	if correlationID == "" {
+		correlationID = id
+	}
+	return Envelope{
+		EventID:       id,
+		Type:          typ,
+		SchemaVersion: version,
+		OccurredAt:    time.Now().UTC(),
+		Producer:      producer,
+		CorrelationID: correlationID,
+	}
+}
+
+// Headers returns the envelope as message headers.
+func (e Envelope) Headers() []kafka.Header {
+	return []kafka.Header{
+		{Key: HeaderEventID, Value: []byte(e.EventID)},
+		{Key: HeaderEventType, Value: []byte(e.Type)},
+		{Key: HeaderSchemaVersion, Value: []byte(strconv.Itoa(e.SchemaVersion))},
+		{Key: HeaderOccurredAt, Value: []byte(e.OccurredAt.Format(time.RFC3339Nano))},
+		{Key: HeaderProducer, Value: []byte(e.Producer)},
+		{Key: HeaderCorrelationID, Value: []byte(e.CorrelationID)},
+	}
+}
+
+// ParseEnvelope reads the envelope from the headers of m. Messages written before envelopes existed
+// have no event type; ok is false for them.
+func ParseEnvelope(m kafka.Message) (e Envelope, ok bool, err error) {
+	const op = "kafka.ParseEnvelope"
+	for _, h := range m.Headers {
+		v := string(h.Value)
+		switch h.Key {
+		case HeaderEventID:
+			e.EventID = v
+		case HeaderEventType:
+			e.Type = v
+		case HeaderSchemaVersion:
+			if e.SchemaVersion, err = strconv.Atoi(v); err != nil || e.SchemaVersion < 1 {
+				return e, true, fmt.Errorf("%s: %w: schema version %q", op, ErrBadEnvelope, v)
+			}
+		case HeaderOccurredAt:
+			if e.OccurredAt, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				return e, true, fmt.Errorf("%s: %w: occurred at %q", op, ErrBadEnvelope, v)
+			}
+		case HeaderProducer:
+			e.Producer = v
+		case HeaderCorrelationID:
+			e.CorrelationID = v
+		}
+	}
+	if e.Type == "" {
+		return e, false, nil
+	}
+	if e.SchemaVersion == 0 {
+		return e, true, fmt.Errorf("%s: %w: no schema version", op, ErrBadEnvelope)
+	}
+	return e, true, nil
+}
+
+type correlationKey struct{}
+
+// WithCorrelationID returns a context whose writes are correlated with id, usually the CorrelationID
+// of the message being handled.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
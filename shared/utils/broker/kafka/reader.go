@@ -2,20 +2,35 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	kafka2 "github.com/kxddry/lectura/shared/entities/config/kafka"
-	"github.com/kxddry/lectura/shared/entities/summarized"
-	"github.com/kxddry/lectura/shared/entities/transcribed"
-	"github.com/kxddry/lectura/shared/entities/uploaded"
 	"github.com/segmentio/kafka-go"
+	"sync"
 	"time"
 )
 
-type Reader[T uploaded.Record | transcribed.Record | summarized.Record] struct {
-	r *kafka.Reader
+// Reader consumes a topic with at-least-once delivery: the offset of a message is committed only after it,
+// and every message before it in its partition, was acked.
+type Reader[T any] struct {
+	r         *kafka.Reader
+	policy    redelivery
+	onGiveUp  func(m Message[T], err error) error
+	onInvalid func(m kafka.Message, err error) error
+	event     event
+	// decoders of the accepted schema versions of T, by version
+	decoders map[int]func(c Codec, value []byte) (T, error)
 }
 
-func NewReader[T uploaded.Record | transcribed.Record | summarized.Record](cfg kafka2.ReaderConfig) Reader[T] {
+// redelivery is what happens to nacked messages.
+type redelivery struct {
+	maxAttempts int // 0 redelivers forever
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	maxWait     time.Duration // since the first delivery, for Early errors
+}
+
+func NewReader[T any](cfg kafka2.ReaderConfig) Reader[T] {
 	var startOffset int64
 	switch cfg.StartOffset {
 	case "earliest":
@@ -26,8 +41,9 @@ func NewReader[T uploaded.Record | transcribed.Record | summarized.Record](cfg k
 		startOffset = kafka.LastOffset // fallback
 	}
 
+	ev := eventOf[T]()
 	return Reader[T]{
-		kafka.NewReader(kafka.ReaderConfig{
+		r: kafka.NewReader(kafka.ReaderConfig{
 			Brokers:        cfg.Brokers,
 			GroupID:        cfg.GroupID,
 			Topic:          cfg.Topic,
@@ -36,45 +52,340 @@ func NewReader[T uploaded.Record | transcribed.Record | summarized.Record](cfg k
 			CommitInterval: cfg.CommitInterval,
 			StartOffset:    startOffset,
 		}),
+		policy: redelivery{
+			maxAttempts: cfg.MaxAttempts,
+			backoff:     cfg.RetryBackoff,
+			maxBackoff:  cfg.MaxRetryBackoff,
+			maxWait:     cfg.MaxWait,
+		},
+		event:    ev,
+		decoders: map[int]func(Codec, []byte) (T, error){ev.version: decodeAs[T]},
 	}
 }
 
-func (r Reader[T]) Messages(ctx context.Context) (<-chan T, <-chan error) {
-	msgCh := make(chan T)
-	errCh := make(chan error)
+// Decode makes the reader accept messages of an older schema version, which fn decodes with the codec
+// of the message and converts to the current one.
+func (r *Reader[T]) Decode(version int, fn func(c Codec, value []byte) (T, error)) {
+	r.decoders[version] = fn
+}
 
-	go func() {
-		defer close(msgCh)
-		defer close(errCh)
+// OnGiveUp sets fn to be called with every message whose last attempt was nacked. The message is skipped,
+// unless fn fails: then it is delivered again, as if it had attempts left.
+func (r *Reader[T]) OnGiveUp(fn func(m Message[T], err error) error) {
+	r.onGiveUp = fn
+}
+
+// OnInvalid sets fn to be called with every message that cannot be delivered: one of another event type,
+// of a schema version without a decoder, with a bad envelope or an undecodable payload. The error wraps
+// ErrInvalidMessage. The message is skipped, unless fn fails: then the reader stops with that error, and the
+// message is read again after a restart. Without fn, invalid messages stop the reader.
+func (r *Reader[T]) OnInvalid(fn func(m kafka.Message, err error) error) {
+	r.onInvalid = fn
+}
+
+// decode returns the record of m and its envelope. Messages without an envelope are taken for version 1 of T,
+// messages without a content type for the codec T is registered with.
+func (r Reader[T]) decode(m kafka.Message) (T, Envelope, error) {
+	const op = "kafka.Reader.decode"
+	var record T
+	typ := r.event.typ
+
+	env, ok, err := ParseEnvelope(m)
+	if err != nil {
+		return record, env, err
+	}
+	if !ok {
+		env = Envelope{Type: typ, SchemaVersion: 1, OccurredAt: m.Time}
+	}
+	if env.Type != typ {
+		return record, env, fmt.Errorf("%s: %w %q, expected %q", op, ErrUnknownType, env.Type, typ)
+	}
+	decode, ok := r.decoders[env.SchemaVersion]
+	if !ok {
+		return record, env, fmt.Errorf("%s: %w %d of %s", op, ErrUnsupportedVersion, env.SchemaVersion, typ)
+	}
+	codec := r.event.codec
+	for _, h := range m.Headers {
+		if h.Key == HeaderContentType {
+			if codec, ok = codecByContentType(string(h.Value)); !ok {
+				return record, env, fmt.Errorf("%s: %w: content type %q", op, ErrDecode, h.Value)
+			}
+		}
+	}
+	if record, err = decode(codec, m.Value); err != nil {
+		return record, env, fmt.Errorf("%s: %w: %w", op, ErrDecode, err)
+	}
+	return record, env, nil
+}
+
+// Message is a record delivered by Reader.Messages. Once it is handled, exactly one of Ack or Nack must be called.
+type Message[T any] struct {
+	Value     T
+	Attempt   int // 1 on the first delivery; counted per process, so it starts over after a restart
+	Partition int
+	Offset    int64
+	Envelope  Envelope
+	Raw       kafka.Message
+	Received  time.Time // of the first delivery
+
+	gen int // of the partition when the message was fetched
+	s   *session[T]
+}
+
+// Ack marks the message as handled. Its offset is committed as soon as all earlier messages of its partition are acked.
+func (m Message[T]) Ack() error {
+	return m.s.done(m.Partition, m.Offset, m.gen)
+}
+
+// Nack marks the message as failed with err. It is delivered again after a backoff, or skipped and given up
+// after the last attempt, or right away if err is Permanent. An Early err gets attempts past the last one until
+// max_wait has passed since the first delivery. Until then, no later offset of its partition is committed.
+func (m Message[T]) Nack(err error) error {
+	return m.s.nack(m, err)
+}
 
+// session tracks the messages delivered by one call of Messages.
+type session[T any] struct {
+	ctx    context.Context
+	r      Reader[T]
+	commit committer
+	msgCh  chan Message[T]
+
+	mu         sync.Mutex
+	partitions map[int]*partition
+	closed     bool
+	senders    sync.WaitGroup
+
+	commitMu sync.Mutex // keeps commits in order, without holding mu during the round trip
+}
+
+// committer commits offsets, like *kafka.Reader.
+type committer interface {
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// partition is the state of a partition since it was last assigned to the reader.
+type partition struct {
+	gen       int        // incremented whenever the partition is assigned again
+	pending   []*pending // in offset order
+	committed int64      // offset of the last committed message; -1 if none
+}
+
+type pending struct {
+	msg  kafka.Message
+	done bool
+}
+
+// Messages delivers the records of the topic until ctx is done; the channels are closed afterwards.
+// Messages that cannot be delivered go to the OnInvalid hook. A fatal error of the reader is sent to the error channel.
+func (r Reader[T]) Messages(ctx context.Context) (<-chan Message[T], <-chan error) {
+	s := &session[T]{ctx: ctx, r: r, commit: r.r, msgCh: make(chan Message[T]), partitions: make(map[int]*partition)}
+	errCh := make(chan error, 1)
+
+	s.senders.Add(1)
+	go func() {
+		defer s.senders.Done()
 		for {
 			m, err := r.r.FetchMessage(ctx)
 			if err != nil {
-				if ctx.Err() != nil {
-					return
+				if ctx.Err() == nil {
+					errCh <- err
 				}
-				errCh <- err
 				return
 			}
+			gen := s.track(m)
 
-			var record T
-			if err = json.Unmarshal(m.Value, &record); err != nil {
+			record, env, err := r.decode(m)
+			if err != nil {
+				if err = s.invalid(m, gen, err); err != nil && ctx.Err() == nil {
+					errCh <- err
+					return
+				}
 				continue
 			}
 
 			select {
 			case <-ctx.Done():
 				return
-			case msgCh <- record:
-				if err = r.r.CommitMessages(ctx, m); err != nil {
-					errCh <- err
-					return
-				}
+			case s.msgCh <- Message[T]{Value: record, Attempt: 1, Partition: m.Partition, Offset: m.Offset, Envelope: env, Raw: m, Received: time.Now(), gen: gen, s: s}:
 			}
 		}
 	}()
 
-	return msgCh, errCh
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+		s.senders.Wait()
+		close(s.msgCh)
+		close(errCh)
+	}()
+
+	return s.msgCh, errCh
+}
+
+// track adds a fetched message to the pending ones of its partition and returns the generation of the partition.
+func (s *session[T]) track(m kafka.Message) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.partitions[m.Partition]
+	if !ok {
+		p = &partition{committed: -1}
+		s.partitions[m.Partition] = p
+	}
+	if n := len(p.pending); (n > 0 && m.Offset <= p.pending[n-1].msg.Offset) || m.Offset <= p.committed {
+		// the partition was revoked and assigned again after a rebalance, so it is read again from the
+		// committed offset: the messages of the earlier assignment can no longer be committed by this reader
+		p.gen++
+		p.pending = nil
+		p.committed = -1
+	}
+	p.pending = append(p.pending, &pending{msg: m})
+	return p.gen
+}
+
+// done marks the message at offset of partition as handled and commits the offsets of the partition
+// up to the first one still pending. Messages of an earlier generation of the partition are ignored.
+func (s *session[T]) done(partition int, offset int64, gen int) error {
+	s.mu.Lock()
+	p, ok := s.partitions[partition]
+	if !ok || p.gen != gen {
+		s.mu.Unlock()
+		return nil
+	}
+	for _, e := range p.pending {
+		if e.msg.Offset == offset {
+			e.done = true
+			break
+		}
+	}
+	n := 0
+	for n < len(p.pending) && p.pending[n].done {
+		n++
+	}
+	if n == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	last := p.pending[n-1].msg
+	p.pending = p.pending[n:]
+	s.mu.Unlock()
+
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+	s.mu.Lock()
+	// a later offset may have been committed, or the partition reassigned, while this commit waited
+	stale := p.gen != gen || last.Offset <= p.committed
+	s.mu.Unlock()
+	if stale {
+		return nil
+	}
+	if err := s.commit.CommitMessages(context.WithoutCancel(s.ctx), last); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if p.gen == gen {
+		p.committed = last.Offset
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// invalid hands a message that cannot be delivered to the OnInvalid hook and skips it.
+func (s *session[T]) invalid(m kafka.Message, gen int, err error) error {
+	if s.r.onInvalid == nil {
+		return err
+	}
+	if err = s.r.onInvalid(m, err); err != nil {
+		return err
+	}
+	return s.done(m.Partition, m.Offset, gen)
+}
+
+func (s *session[T]) nack(m Message[T], err error) error {
+	p := s.r.policy
+	waiting := IsEarly(err) && time.Since(m.Received) < p.maxWait
+	if IsPermanent(err) || (!waiting && p.maxAttempts > 0 && m.Attempt >= p.maxAttempts) {
+		if s.r.onGiveUp == nil || s.r.onGiveUp(m, err) == nil {
+			return s.done(m.Partition, m.Offset, m.gen)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		// not committed, so it is delivered again after a restart
+		return nil
+	}
+	s.senders.Add(1)
+	go func() {
+		defer s.senders.Done()
+		timer := time.NewTimer(s.r.policy.delay(m.Attempt))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-s.ctx.Done():
+			return
+		}
+
+		m.Attempt++
+		select {
+		case s.msgCh <- m:
+		case <-s.ctx.Done():
+		}
+	}()
+	return nil
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as one that retrying will not fix, so the message is given up on its first Nack.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var pe permanentError
+	return errors.As(err, &pe)
+}
+
+type earlyError struct{ err error }
+
+func (e earlyError) Error() string { return e.err.Error() }
+
+func (e earlyError) Unwrap() error { return e.err }
+
+// Early marks err as caused by a message that arrived before one it depends on, e.g. a transcript
+// before its file, which is read from another topic. Waiting for it does not use up max_attempts.
+func Early(err error) error {
+	if err == nil {
+		return nil
+	}
+	return earlyError{err: err}
+}
+
+// IsEarly reports whether err was marked with Early.
+func IsEarly(err error) bool {
+	var ee earlyError
+	return errors.As(err, &ee)
+}
+
+// delay returns the backoff before the redelivery that follows attempt: doubled after every attempt, capped.
+func (p redelivery) delay(attempt int) time.Duration {
+	d := p.backoff << min(attempt-1, 30)
+	if p.maxBackoff > 0 && (d > p.maxBackoff || d <= 0) {
+		d = p.maxBackoff
+	}
+	return max(d, 0)
 }
 
 func (r Reader[T]) CheckAlive() error {
@@ -0,0 +1,340 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"github.com/segmentio/kafka-go"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRecord is the message type of the tests.
+type testRecord struct {
+	UUID string `json:"uuid"`
+}
+
+const testEventType = "test.record"
+
+func init() {
+	Register[testRecord](testEventType, 1, JSON)
+}
+
+// fakeCommitter records the offsets committed per partition.
+type fakeCommitter struct {
+	mu        sync.Mutex
+	committed map[int][]int64
+	err       error
+}
+
+func (f *fakeCommitter) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	for _, m := range msgs {
+		f.committed[m.Partition] = append(f.committed[m.Partition], m.Offset)
+	}
+	return nil
+}
+
+func (f *fakeCommitter) offsets(partition int) []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.committed[partition])
+}
+
+func newTestSession(t *testing.T, policy redelivery) (*session[testRecord], *fakeCommitter) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	fc := &fakeCommitter{committed: make(map[int][]int64)}
+	return &session[testRecord]{
+		ctx:        ctx,
+		r:          Reader[testRecord]{policy: policy},
+		commit:     fc,
+		msgCh:      make(chan Message[testRecord], 1),
+		partitions: make(map[int]*partition),
+	}, fc
+}
+
+func TestDelay(t *testing.T) {
+	p := redelivery{backoff: 10 * time.Second, maxBackoff: 5 * time.Minute}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{5, 160 * time.Second},
+		{6, 5 * time.Minute}, // 320s, capped
+		{100, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := p.delay(tt.attempt); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+	if got := (redelivery{backoff: time.Second}).delay(100); got <= 0 {
+		t.Errorf("uncapped delay overflowed to %v", got)
+	}
+}
+
+func TestDoneOutOfOrder(t *testing.T) {
+	s, fc := newTestSession(t, redelivery{})
+	gens := map[int]int{}
+	for _, part := range []int{0, 1} {
+		for off := range int64(5) {
+			gens[part] = s.track(kafka.Message{Partition: part, Offset: off})
+		}
+	}
+
+	steps := []struct {
+		partition int
+		offset    int64
+		want      []int64 // committed offsets of the partition afterwards
+	}{
+		{0, 2, nil},
+		{0, 1, nil},
+		{1, 0, []int64{0}},
+		{0, 0, []int64{2}},
+		{0, 4, []int64{2}},
+		{0, 4, []int64{2}}, // acked twice
+		{0, 3, []int64{2, 4}},
+		{1, 1, []int64{0, 1}},
+	}
+	for i, st := range steps {
+		if err := s.done(st.partition, st.offset, gens[st.partition]); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if got := fc.offsets(st.partition); !slices.Equal(got, st.want) {
+			t.Fatalf("step %d: partition %d committed %v, want %v", i, st.partition, got, st.want)
+		}
+	}
+}
+
+func TestDoneAfterRebalance(t *testing.T) {
+	s, fc := newTestSession(t, redelivery{})
+	old := s.track(kafka.Message{Offset: 0})
+	s.track(kafka.Message{Offset: 1})
+	s.track(kafka.Message{Offset: 2})
+	if err := s.done(0, 0, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// the partition is assigned again and read from the committed offset
+	gen := s.track(kafka.Message{Offset: 1})
+	if gen == old {
+		t.Fatal("generation did not change")
+	}
+	s.track(kafka.Message{Offset: 2})
+
+	// acks of the earlier assignment are ignored
+	if err := s.done(0, 1, old); err != nil {
+		t.Fatal(err)
+	}
+	if got := fc.offsets(0); !slices.Equal(got, []int64{0}) {
+		t.Fatalf("committed %v after a stale ack", got)
+	}
+	if err := s.done(0, 1, gen); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.done(0, 2, gen); err != nil {
+		t.Fatal(err)
+	}
+	if got := fc.offsets(0); !slices.Equal(got, []int64{0, 1, 2}) {
+		t.Fatalf("committed %v, want [0 1 2]", got)
+	}
+}
+
+func TestNack(t *testing.T) {
+	errFailed := errors.New("failed")
+	message := func(s *session[testRecord], attempt int) Message[testRecord] {
+		raw := kafka.Message{Offset: 0}
+		return Message[testRecord]{Attempt: attempt, Raw: raw, gen: s.track(raw), s: s}
+	}
+
+	t.Run("redelivers with the next attempt", func(t *testing.T) {
+		s, fc := newTestSession(t, redelivery{maxAttempts: 3, backoff: time.Millisecond})
+		if err := message(s, 1).Nack(errFailed); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case m := <-s.msgCh:
+			if m.Attempt != 2 {
+				t.Fatalf("attempt %d, want 2", m.Attempt)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("not redelivered")
+		}
+		if got := fc.offsets(0); got != nil {
+			t.Fatalf("committed %v before the message was handled", got)
+		}
+	})
+
+	t.Run("gives up after the last attempt", func(t *testing.T) {
+		s, fc := newTestSession(t, redelivery{maxAttempts: 3, backoff: time.Millisecond})
+		var gaveUp error
+		s.r.onGiveUp = func(m Message[testRecord], err error) error {
+			gaveUp = err
+			return nil
+		}
+		if err := message(s, 3).Nack(errFailed); err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(gaveUp, errFailed) {
+			t.Fatalf("give-up hook got %v", gaveUp)
+		}
+		if got := fc.offsets(0); !slices.Equal(got, []int64{0}) {
+			t.Fatalf("committed %v, want [0]", got)
+		}
+	})
+
+	t.Run("gives up at once on a permanent error", func(t *testing.T) {
+		s, fc := newTestSession(t, redelivery{maxAttempts: 3, backoff: time.Millisecond})
+		var gaveUp error
+		s.r.onGiveUp = func(m Message[testRecord], err error) error {
+			gaveUp = err
+			return nil
+		}
+		if err := message(s, 1).Nack(Permanent(errFailed)); err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(gaveUp, errFailed) || !IsPermanent(gaveUp) {
+			t.Fatalf("give-up hook got %v", gaveUp)
+		}
+		if got := fc.offsets(0); !slices.Equal(got, []int64{0}) {
+			t.Fatalf("committed %v, want [0]", got)
+		}
+	})
+
+	t.Run("waits for an early message past the last attempt", func(t *testing.T) {
+		s, _ := newTestSession(t, redelivery{maxAttempts: 3, backoff: time.Millisecond, maxWait: time.Hour})
+		s.r.onGiveUp = func(m Message[testRecord], err error) error {
+			t.Fatalf("gave up on an early message: %v", err)
+			return nil
+		}
+		m := message(s, 3)
+		m.Received = time.Now()
+		if err := m.Nack(Early(errFailed)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case m := <-s.msgCh:
+			if m.Attempt != 4 {
+				t.Fatalf("attempt %d, want 4", m.Attempt)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("not redelivered")
+		}
+	})
+
+	t.Run("gives up on an early message after max wait", func(t *testing.T) {
+		s, fc := newTestSession(t, redelivery{maxAttempts: 3, backoff: time.Millisecond, maxWait: time.Hour})
+		var gaveUp error
+		s.r.onGiveUp = func(m Message[testRecord], err error) error {
+			gaveUp = err
+			return nil
+		}
+		m := message(s, 3)
+		m.Received = time.Now().Add(-2 * time.Hour)
+		if err := m.Nack(Early(errFailed)); err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(gaveUp, errFailed) {
+			t.Fatalf("give-up hook got %v", gaveUp)
+		}
+		if got := fc.offsets(0); !slices.Equal(got, []int64{0}) {
+			t.Fatalf("committed %v, want [0]", got)
+		}
+	})
+
+	t.Run("redelivers if the give-up hook fails", func(t *testing.T) {
+		s, fc := newTestSession(t, redelivery{maxAttempts: 3, backoff: time.Millisecond})
+		s.r.onGiveUp = func(m Message[testRecord], err error) error { return errors.New("dlq unavailable") }
+		if err := message(s, 3).Nack(errFailed); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case m := <-s.msgCh:
+			if m.Attempt != 4 {
+				t.Fatalf("attempt %d, want 4", m.Attempt)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("not redelivered")
+		}
+		if got := fc.offsets(0); got != nil {
+			t.Fatalf("committed %v although the message was not dead-lettered", got)
+		}
+	})
+}
+
+func TestInvalid(t *testing.T) {
+	errInvalid := ErrDecode
+	tests := []struct {
+		name      string
+		hook      func(kafka.Message, error) error
+		wantErr   bool
+		committed []int64
+	}{
+		{name: "without a hook the reader stops", wantErr: true},
+		{name: "skipped once the hook handled it", hook: func(kafka.Message, error) error { return nil }, committed: []int64{0}},
+		{name: "kept if the hook fails", hook: func(kafka.Message, error) error { return errors.New("dlq unavailable") }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fc := newTestSession(t, redelivery{})
+			s.r.onInvalid = tt.hook
+			m := kafka.Message{Offset: 0}
+			err := s.invalid(m, s.track(m), errInvalid)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if got := fc.offsets(0); !slices.Equal(got, tt.committed) {
+				t.Fatalf("committed %v, want %v", got, tt.committed)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	r := Reader[testRecord]{event: eventOf[testRecord]()}
+	r.decoders = map[int]func(Codec, []byte) (testRecord, error){r.event.version: decodeAs[testRecord]}
+	value := []byte(`{"uuid":"abc"}`)
+	envelope := func(typ string, version int) []kafka.Header {
+		return NewEnvelope(context.Background(), typ, version, "test").Headers()
+	}
+
+	tests := []struct {
+		name    string
+		msg     kafka.Message
+		wantErr error
+	}{
+		{name: "current version", msg: kafka.Message{Value: value, Headers: envelope(testEventType, 1)}},
+		{name: "no envelope is version 1", msg: kafka.Message{Value: value}},
+		{name: "another type", msg: kafka.Message{Value: value, Headers: envelope("file.summarized", 1)}, wantErr: ErrUnknownType},
+		{name: "newer version", msg: kafka.Message{Value: value, Headers: envelope(testEventType, 99)}, wantErr: ErrUnsupportedVersion},
+		{name: "bad schema version", msg: kafka.Message{Value: value, Headers: []kafka.Header{
+			{Key: HeaderEventType, Value: []byte(testEventType)}, {Key: HeaderSchemaVersion, Value: []byte("x")},
+		}}, wantErr: ErrBadEnvelope},
+		{name: "unknown content type", msg: kafka.Message{Value: value, Headers: append(envelope(testEventType, 1),
+			kafka.Header{Key: HeaderContentType, Value: []byte("text/csv")})}, wantErr: ErrDecode},
+		{name: "undecodable payload", msg: kafka.Message{Value: []byte("{"), Headers: envelope(testEventType, 1)}, wantErr: ErrDecode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, _, err := r.decode(tt.msg)
+			if tt.wantErr == nil {
+				if err != nil || rec.UUID != "abc" {
+					t.Fatalf("got %+v, %v", rec, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) || !errors.Is(err, ErrInvalidMessage) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
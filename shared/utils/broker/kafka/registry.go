@@ -0,0 +1,58 @@
+package kafka
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// event is how a registered type is written.
+type event struct {
+	typ     string
+	version int
+	codec   Codec
+}
+
+var events = struct {
+	sync.RWMutex
+	byType map[reflect.Type]event
+}{byType: map[reflect.Type]event{}}
+
+// Register makes T a message type that Reader and Writer accept: it is the event typ in its current
+// schema version, encoded with codec unless a writer is configured otherwise. Protobuf types are
+// registered as their pointer type. Types register themselves in the package that defines them,
+// the records of the pipeline in shared/entities.
+func Register[T any](typ string, version int, codec Codec) {
+	events.Lock()
+	defer events.Unlock()
+	events.byType[reflect.TypeFor[T]()] = event{typ: typ, version: version, codec: codec}
+}
+
+// EventType returns the event type T is registered as, and false if it is not registered.
+func EventType[T any]() (string, bool) {
+	events.RLock()
+	defer events.RUnlock()
+	e, ok := events.byType[reflect.TypeFor[T]()]
+	return e.typ, ok
+}
+
+// eventOf returns the registration of T. Using a type that was never registered is a bug, so it panics.
+func eventOf[T any]() event {
+	events.RLock()
+	defer events.RUnlock()
+	e, ok := events.byType[reflect.TypeFor[T]()]
+	if !ok {
+		panic(fmt.Sprintf("kafka: message type %v is not registered", reflect.TypeFor[T]()))
+	}
+	return e
+}
+
+// decodeAs decodes data into a new T; pointer types get a new value to point to.
+func decodeAs[T any](c Codec, data []byte) (T, error) {
+	var v T
+	if t := reflect.TypeFor[T](); t.Kind() == reflect.Pointer {
+		v = reflect.New(t.Elem()).Interface().(T)
+		return v, c.Unmarshal(data, v)
+	}
+	return v, c.Unmarshal(data, &v)
+}
@@ -2,32 +2,93 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	kafka2 "github.com/kxddry/lectura/shared/entities/config/kafka"
-	"github.com/kxddry/lectura/shared/entities/summarized"
-	"github.com/kxddry/lectura/shared/entities/transcribed"
-	"github.com/kxddry/lectura/shared/entities/uploaded"
 	"github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/compress"
+	"reflect"
 	"time"
 )
 
-type Writer[T uploaded.Record | transcribed.Record | summarized.Record] struct {
-	w *kafka.Writer
+// Writer publishes records of a registered type T, see Register.
+type Writer[T any] struct {
+	w        *kafka.Writer
+	producer string
+	event    event
+	codec    Codec
 }
 
+// Keyer is implemented by records with a partition key. Records with the same key are hashed to the same
+// partition, so they are consumed in the order they were written; records without one are spread evenly.
+type Keyer interface {
+	PartitionKey() string
+}
+
+// Delivery is the outcome of an asynchronous write.
+type Delivery struct {
+	EventID string
+	Key     string
+	Err     error // nil if the message was delivered
+}
+
+// Write publishes record as a new event; its envelope is correlated with the event in ctx, see WithCorrelationID.
+// In sync mode it returns once the message is acked by the brokers. In async mode it returns right away,
+// and delivery errors only reach the OnDelivery callback.
 func (w Writer[T]) Write(ctx context.Context, record T) error {
-	msgBytes, err := json.Marshal(record)
+	msgBytes, err := w.codec.Marshal(record)
 	if err != nil {
 		return err
 	}
+	headers := NewEnvelope(ctx, w.event.typ, w.event.version, w.producer).Headers()
 	msg := kafka.Message{
-		Value: msgBytes,
+		Value:   msgBytes,
+		Headers: append(headers, kafka.Header{Key: HeaderContentType, Value: []byte(w.codec.ContentType())}),
+	}
+	if k, ok := any(record).(Keyer); ok {
+		msg.Key = []byte(k.PartitionKey())
 	}
 	return w.w.WriteMessages(ctx, msg)
 }
 
-func NewWriter[T uploaded.Record | transcribed.Record | summarized.Record](cfg kafka2.WriterConfig) Writer[T] {
+// OnDelivery sets fn to be called with the outcome of every message written in async mode.
+// It is called from the goroutine of the writer, so it must not block.
+func (w *Writer[T]) OnDelivery(fn func(d Delivery)) {
+	w.w.Completion = func(messages []kafka.Message, err error) {
+		for _, m := range messages {
+			d := Delivery{Key: string(m.Key), Err: err}
+			for _, h := range m.Headers {
+				if h.Key == HeaderEventID {
+					d.EventID = string(h.Value)
+				}
+			}
+			fn(d)
+		}
+	}
+}
+
+// Close flushes the messages still buffered in async mode and closes the writer.
+func (w Writer[T]) Close() error {
+	return w.w.Close()
+}
+
+// NewWriter returns a writer of T to cfg.Topic. It fails if the configured codec is unknown
+// or cannot encode T.
+func NewWriter[T any](cfg kafka2.WriterConfig) (Writer[T], error) {
+	const op = "kafka.NewWriter"
+	ev := eventOf[T]()
+	codec := ev.codec
+	if cfg.Codec != "" {
+		var err error
+		if codec, err = CodecByName(cfg.Codec); err != nil {
+			return Writer[T]{}, fmt.Errorf("%s: %s: %w", op, cfg.Topic, err)
+		}
+	}
+	if tc, ok := codec.(TypeChecker); ok {
+		if err := tc.CanEncode(reflect.TypeFor[T]()); err != nil {
+			return Writer[T]{}, fmt.Errorf("%s: %s: %w", op, cfg.Topic, err)
+		}
+	}
+
 	var compression kafka.Compression
 
 	switch cfg.Compression {
@@ -58,15 +119,16 @@ func NewWriter[T uploaded.Record | transcribed.Record | summarized.Record](cfg k
 	w := &kafka.Writer{
 		Addr:                   kafka.TCP(cfg.Brokers...),
 		Topic:                  cfg.Topic,
-		Balancer:               &kafka.RoundRobin{},
+		Balancer:               &kafka.Hash{},
 		MaxAttempts:            cfg.Retries,
 		RequiredAcks:           requiredAcks,
-		Async:                  true,
+		Async:                  cfg.Async,
+		BatchTimeout:           cfg.BatchTimeout,
 		Compression:            compression,
 		WriteTimeout:           cfg.Timeout,
 		AllowAutoTopicCreation: false,
 	}
-	return Writer[T]{w: w}
+	return Writer[T]{w: w, producer: cfg.ClientID, event: ev, codec: codec}, nil
 }
 
 func (w Writer[T]) CheckAlive(brokers []string) error {
@@ -0,0 +1,33 @@
+package kafka
+
+import (
+	"errors"
+	kafka2 "github.com/kxddry/lectura/shared/entities/config/kafka"
+	"testing"
+)
+
+func TestNewWriterCodec(t *testing.T) {
+	tests := []struct {
+		codec   string
+		wantErr bool
+	}{
+		{"", false},
+		{"json", false},
+		{"protobuf", true}, // testRecord is not a proto.Message
+		{"xml", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.codec, func(t *testing.T) {
+			w, err := NewWriter[testRecord](kafka2.WriterConfig{Brokers: []string{"localhost:9092"}, Topic: "test", Codec: tt.codec})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil {
+				_ = w.Close()
+			}
+			if tt.codec == "xml" && !errors.Is(err, ErrUnknownCodec) {
+				t.Fatalf("err = %v, want %v", err, ErrUnknownCodec)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package formats
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Format is an accepted media format. The first mime type is the canonical one.
+type Format struct {
+	Name        string        `yaml:"name"`
+	MimeTypes   []string      `yaml:"mime_types"`
+	Extension   string        `yaml:"extension"` // canonical extension, stored as the original file's extension
+	Video       bool          `yaml:"video"`
+	MaxDuration time.Duration `yaml:"max_duration"` // 0 means no limit
+	MaxSize     int64         `yaml:"max_size"`     // bytes, 0 means no limit
+}
+
+// MimeType returns the canonical mime type of the format.
+func (f Format) MimeType() string {
+	return f.MimeTypes[0]
+}
+
+// Registry looks formats up by mime type and extension.
+type Registry struct {
+	formats []Format
+	byMime  map[string]int
+	byExt   map[string]int
+}
+
+// New validates formats and builds a registry. Mime types and extensions must be unique.
+func New(formats []Format) (*Registry, error) {
+	const op = "formats.New"
+	r := &Registry{
+		formats: make([]Format, 0, len(formats)),
+		byMime:  make(map[string]int),
+		byExt:   make(map[string]int),
+	}
+	for _, f := range formats {
+		if f.Name == "" || len(f.MimeTypes) == 0 || !strings.HasPrefix(f.Extension, ".") {
+			return nil, fmt.Errorf("%s: %w", op, fmt.Errorf("format %q needs a name, mime types and an extension starting with a dot", f.Name))
+		}
+		if f.MaxDuration < 0 || f.MaxSize < 0 {
+			return nil, fmt.Errorf("%s: %w", op, fmt.Errorf("format %q has negative limits", f.Name))
+		}
+
+		i := len(r.formats)
+		f.MimeTypes = slices.Clone(f.MimeTypes)
+		f.Extension = strings.ToLower(f.Extension)
+		if _, ok := r.byExt[f.Extension]; ok {
+			return nil, fmt.Errorf("%s: %w", op, fmt.Errorf("duplicate extension %s", f.Extension))
+		}
+		r.byExt[f.Extension] = i
+		for j, m := range f.MimeTypes {
+			m = strings.ToLower(m)
+			if _, ok := r.byMime[m]; ok {
+				return nil, fmt.Errorf("%s: %w", op, fmt.Errorf("duplicate mime type %s", m))
+			}
+			f.MimeTypes[j] = m
+			r.byMime[m] = i
+		}
+		r.formats = append(r.formats, f)
+	}
+	if len(r.formats) == 0 {
+		return nil, fmt.Errorf("%s: %w", op, errors.New("no formats configured"))
+	}
+	return r, nil
+}
+
+// Load builds a registry from the configured formats, or from Default if none are configured.
+func Load(formats []Format) (*Registry, error) {
+	if len(formats) == 0 {
+		formats = Default()
+	}
+	return New(formats)
+}
+
+// ByMime returns the format with the given mime type.
+func (r *Registry) ByMime(mimeType string) (Format, bool) {
+	i, ok := r.byMime[strings.ToLower(mimeType)]
+	if !ok {
+		return Format{}, false
+	}
+	return r.formats[i], true
+}
+
+// ByExtension returns the format with the given canonical extension, e.g. ".mp3".
+func (r *Registry) ByExtension(ext string) (Format, bool) {
+	i, ok := r.byExt[strings.ToLower(ext)]
+	if !ok {
+		return Format{}, false
+	}
+	return r.formats[i], true
+}
+
+// MimeType maps a canonical extension to its mime type, or returns "" if it is unknown.
+func (r *Registry) MimeType(ext string) string {
+	f, ok := r.ByExtension(ext)
+	if !ok {
+		return ""
+	}
+	return f.MimeType()
+}
+
+// Formats returns every registered format.
+func (r *Registry) Formats() []Format {
+	return append([]Format(nil), r.formats...)
+}
+
+const (
+	defaultMaxDuration = 4 * time.Hour
+	defaultMaxSize     = 1 << 30 // 1GB, same as nginx
+)
+
+// Default is used when the config has no formats section.
+func Default() []Format {
+	formats := []Format{
+		{Name: "mp4", MimeTypes: []string{"video/mp4"}, Extension: ".mp4", Video: true},
+		{Name: "mov", MimeTypes: []string{"video/quicktime"}, Extension: ".mov", Video: true},
+		{Name: "avi", MimeTypes: []string{"video/x-msvideo", "video/avi", "video/msvideo"}, Extension: ".avi", Video: true},
+		{Name: "mkv", MimeTypes: []string{"video/x-matroska"}, Extension: ".mkv", Video: true},
+		{Name: "webm", MimeTypes: []string{"video/webm", "audio/webm"}, Extension: ".webm", Video: true},
+		{Name: "aac", MimeTypes: []string{"audio/aac"}, Extension: ".aac"},
+		{Name: "wav", MimeTypes: []string{"audio/wav", "audio/x-wav", "audio/vnd.wave"}, Extension: ".wav"},
+		{Name: "ogg", MimeTypes: []string{"audio/ogg", "audio/opus", "application/ogg"}, Extension: ".ogg"},
+		{Name: "mp3", MimeTypes: []string{"audio/mpeg", "audio/x-mpeg", "audio/mp3"}, Extension: ".mp3"},
+		{Name: "m4a", MimeTypes: []string{"audio/x-m4a", "audio/mp4"}, Extension: ".m4a"},
+		{Name: "flac", MimeTypes: []string{"audio/flac", "audio/x-flac"}, Extension: ".flac"},
+	}
+	for i := range formats {
+		formats[i].MaxDuration = defaultMaxDuration
+		formats[i].MaxSize = defaultMaxSize
+	}
+	return formats
+}
@@ -0,0 +1,67 @@
+package reporter
+
+import (
+	"context"
+	"github.com/kxddry/lectura/shared/entities/progress"
+	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
+	"log/slog"
+	"time"
+)
+
+type Writer interface {
+	Write(ctx context.Context, record progress.Record) error
+}
+
+// Reporter publishes the progress of one stage of one file. Progress is best effort:
+// failed writes are logged and never fail the stage.
+type Reporter struct {
+	ctx   context.Context
+	log   *slog.Logger
+	w     Writer
+	uuid  string
+	stage progress.Stage
+	start time.Time
+}
+
+// Start publishes that the stage started and returns a Reporter for the rest of it.
+func Start(ctx context.Context, log *slog.Logger, w Writer, uuid string, stage progress.Stage) *Reporter {
+	r := &Reporter{ctx: ctx, log: log, w: w, uuid: uuid, stage: stage, start: time.Now()}
+	r.write(progress.Record{Status: progress.Started})
+	return r
+}
+
+// Progress publishes that done of total parts of the stage are finished, with the time left
+// estimated from the time the finished parts took.
+func (r *Reporter) Progress(done, total int) {
+	if total <= 0 || done <= 0 {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	r.write(progress.Record{
+		Status:  progress.Running,
+		Percent: 100 * float64(done) / float64(total),
+		ETA:     elapsed / float64(done) * float64(total-done),
+	})
+}
+
+// Finish publishes that the stage is done. After an error nothing is published: the message is delivered
+// again and the stage starts over, or it is given up on, and Fail publishes that.
+func (r *Reporter) Finish(err error) {
+	if err != nil {
+		return
+	}
+	r.write(progress.Record{Status: progress.Done, Percent: 100})
+}
+
+// Fail publishes that the stage of a file failed for good with err.
+func Fail(ctx context.Context, log *slog.Logger, w Writer, uuid string, stage progress.Stage, err error) {
+	r := &Reporter{ctx: ctx, log: log, w: w, uuid: uuid, stage: stage}
+	r.write(progress.Record{Status: progress.Failed, Error: err.Error()})
+}
+
+func (r *Reporter) write(rec progress.Record) {
+	rec.UUID, rec.Stage, rec.At = r.uuid, r.stage, time.Now().UTC()
+	if err := r.w.Write(r.ctx, rec); err != nil {
+		r.log.Warn("failed to publish progress", slog.String("uuid", r.uuid), slog.String("status", string(rec.Status)), sl.Err(err))
+	}
+}
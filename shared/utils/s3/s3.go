@@ -32,6 +32,39 @@ func (s3 S3Client) GetPresignedURL(ctx context.Context, bucket, objectName strin
 	return parsed.String(), nil
 }
 
+// GetPresignedPostPolicy returns a URL and form fields that let a browser upload exactly one object
+// straight to S3. The upload is rejected by S3 if the object is larger than maxSize
+// or its Content-Type differs from contentType.
+func (s3 S3Client) GetPresignedPostPolicy(ctx context.Context, bucket, objectName, contentType string, maxSize int64, expiry time.Duration) (string, map[string]string, error) {
+	policy := minio.NewPostPolicy()
+	if err := policy.SetBucket(bucket); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetKey(objectName); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetExpires(time.Now().UTC().Add(expiry)); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetContentType(contentType); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetContentLengthRange(1, maxSize); err != nil {
+		return "", nil, err
+	}
+
+	presignedURL, fields, err := s3.public.PresignedPostPolicy(ctx, policy)
+	if err != nil {
+		return "", nil, err
+	}
+
+	parsed := *presignedURL
+	parsed.Host = s3.publicURL.Host
+	parsed.Scheme = s3.publicURL.Scheme
+
+	return parsed.String(), fields, nil
+}
+
 func NewClient(config s3.StorageConfig) (S3Client, error) {
 	internal, err := minio.New(config.Endpoint, &minio.Options{
 		Creds:  credentials.NewStaticV4(config.AccessKey, config.Secret, ""),
@@ -62,10 +95,51 @@ func (s3 S3Client) Download(ctx context.Context, bucket string, key string) (io.
 	return s3.internal.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
 }
 
+// objectURLExpiry bounds how long the URL of an opened object is valid.
+const objectURLExpiry = time.Hour
+
+// object is an opened S3 object. Reads after a seek are ranged requests, so it is never copied as a whole.
+type object struct {
+	*minio.Object
+	url string
+}
+
+// URL returns a presigned URL of the object on the internal endpoint, for readers that fetch it themselves, like ffmpeg.
+func (o object) URL() string {
+	return o.url
+}
+
+// Open opens an object for reading and seeking. The result also has a URL method, see object.
+func (s3 S3Client) Open(ctx context.Context, bucket string, key string) (io.ReadSeekCloser, error) {
+	u, err := s3.internal.PresignedGetObject(ctx, bucket, key, objectURLExpiry, nil)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s3.internal.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy, a missing object would only fail the first read
+	if _, err = obj.Stat(); err != nil {
+		_ = obj.Close()
+		return nil, err
+	}
+	return object{Object: obj, url: u.String()}, nil
+}
+
+// streamPartSize is the multipart part size used for files of unknown size.
+// minio would otherwise size parts for a 5TiB object and buffer them in memory.
+const streamPartSize = 16 << 20
+
+// Upload stores the file. A negative Size streams the data as a multipart upload.
 func (s3 S3Client) Upload(ctx context.Context, bucket string, file uploaded.File) error {
-	_, err := s3.internal.PutObject(ctx, bucket, file.FullName(), file.Data(), file.Size(), minio.PutObjectOptions{
+	opts := minio.PutObjectOptions{
 		ContentType: file.MimeType(),
-	})
+	}
+	if file.Size() < 0 {
+		opts.PartSize = streamPartSize
+	}
+	_, err := s3.internal.PutObject(ctx, bucket, file.FullName(), file.Data(), file.Size(), opts)
 	return err
 }
 
@@ -73,6 +147,30 @@ func (s3 S3Client) Delete(ctx context.Context, bucket string, key string) error
 	return s3.internal.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{})
 }
 
+// Stat returns the size and content type of an object.
+func (s3 S3Client) Stat(ctx context.Context, bucket, key string) (int64, string, error) {
+	info, err := s3.internal.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return 0, "", err
+	}
+	return info.Size, info.ContentType, nil
+}
+
+// List returns the keys of all objects under prefix, in lexical order.
+func (s3 S3Client) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var keys []string
+	for obj := range s3.internal.ListObjects(ctx, bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
+
 func EnsureBucketExists(ctx context.Context, s3 S3Client, bucket string) error {
 	err := s3.internal.MakeBucket(ctx, bucket, minio.MakeBucketOptions{})
 	if err != nil {
@@ -3,10 +3,13 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/kxddry/lectura/shared/entities/config/db"
+	"github.com/kxddry/lectura/shared/entities/frames"
 	"github.com/kxddry/lectura/shared/entities/frontend"
+	"github.com/kxddry/lectura/shared/entities/progress"
 	"github.com/kxddry/lectura/shared/entities/summarized"
 	"github.com/kxddry/lectura/shared/entities/transcribed"
 	"github.com/kxddry/lectura/shared/entities/uploaded"
@@ -18,42 +21,6 @@ type Client struct {
 	db *sql.DB
 }
 
-func (c *Client) GetFileData(ctx context.Context, uuid string, uid uint) (string, error) {
-	const op = "storage.postgres.getFileData"
-	tx, err := c.db.Begin()
-	if err != nil {
-		return err.Error(), fmt.Errorf("%s: %w", op, err)
-	}
-	defer tx.Rollback()
-
-	var status int
-	err = tx.QueryRowContext(ctx, `SELECT status FROM files WHERE uuid = $1 AND user_id = $2;`, uuid, uid).Scan(&status)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return "This file does not exist", nil
-		}
-		return err.Error(), fmt.Errorf("%s: %w", op, err)
-	}
-
-	if status == 0 {
-		return "Your file has not been processed yet, please wait...", nil
-	} else if status == 1 {
-		var data string
-		err = tx.QueryRowContext(ctx, `SELECT text FROM transcribed WHERE uuid = $1;`, uuid).Scan(&data)
-		if err != nil {
-			return err.Error(), fmt.Errorf("%s: %w", op, err)
-		}
-		return data, nil
-	} else {
-		var data string
-		err = tx.QueryRowContext(ctx, `SELECT text FROM summarized WHERE uuid = $1;`, uuid).Scan(&data)
-		if err != nil {
-			return err.Error(), fmt.Errorf("%s: %w", op, err)
-		}
-		return data, nil
-	}
-}
-
 func New(cfg db.StorageConfig) (*Client, error) {
 	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s", cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
 	_db, err := sql.Open("postgres", dsn)
@@ -68,14 +35,28 @@ func (c *Client) Close() error { return c.db.Close() }
 func (c *Client) AddFile(ctx context.Context, msg uploaded.Record) error {
 	const op = "storage.postgres.addFile"
 
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 	defer tx.Rollback()
 
-	row := tx.QueryRowContext(ctx, `INSERT INTO files (uuid, user_id, og_filename, og_extension, status) VALUES ($1, $2, $3, $4, $5);`,
-		msg.UUID, msg.Update.UserID, msg.Update.OGFileName, msg.Update.OGExtension, 0,
+	var preprocessing sql.NullString
+	if msg.Preprocessing != nil {
+		b, err := json.Marshal(msg.Preprocessing)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		preprocessing = sql.NullString{String: string(b), Valid: true}
+	}
+
+	meta := msg.Metadata
+	row := tx.QueryRowContext(ctx, `INSERT INTO files (uuid, user_id, og_filename, og_extension, status, preprocessing,
+                   title, course, lecture_date, tags, language, audio_hash, duplicate_of)
+                   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13);`,
+		msg.UUID, msg.Update.UserID, msg.Update.OGFileName, msg.Update.OGExtension, 0, preprocessing,
+		nullString(meta.Title), nullString(meta.Course), nullString(meta.LectureDate), pq.StringArray(meta.Tags), nullString(meta.Language),
+		nullString(msg.AudioHash), nullString(msg.DuplicateOf),
 	)
 	if err = row.Err(); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
@@ -84,30 +65,75 @@ func (c *Client) AddFile(ctx context.Context, msg uploaded.Record) error {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
+	for _, r := range msg.Update.Renditions {
+		err = tx.QueryRowContext(ctx, `INSERT INTO renditions (uuid, purpose, key, size, checksum, mime_type) VALUES ($1, $2, $3, $4, $5, $6);`,
+			msg.UUID, r.Purpose, r.Key, r.Size, r.Checksum, r.MimeType,
+		).Err()
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if msg.DuplicateOf != "" {
+		if err = copyResults(ctx, tx, msg.DuplicateOf, msg.UUID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
 	return tx.Commit()
 }
 
 func (c *Client) AddTranscription(ctx context.Context, msg transcribed.Record) error {
 	const op = "storage.postgres.addTranscription"
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 	defer tx.Rollback()
 
-	err = tx.QueryRowContext(ctx, `INSERT INTO transcribed (uuid, text, language) VALUES ($1, $2, $3)`, msg.UUID, msg.Text, msg.Language).Err()
+	settings, err := json.Marshal(msg.Settings)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	var quality []byte
+	if msg.Quality != nil {
+		if quality, err = json.Marshal(msg.Quality); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO transcribed (uuid, text, language, settings, quality) VALUES ($1, $2, $3, $4, $5)`,
+		msg.UUID, msg.Text, msg.Language, settings, quality,
+	).Err()
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
 			return fmt.Errorf("%s: %w", op, storage.ErrUUIDExists)
+		} else if ok && pqErr.Code.Name() == "foreign_key_violation" {
+			return fmt.Errorf("%s: %w", op, storage.ErrUUIDNotFound)
 		}
 		return fmt.Errorf("%s: %w", op, err)
 	}
+
+	for _, seg := range msg.Segments {
+		var words []byte
+		if len(seg.Words) > 0 {
+			if words, err = json.Marshal(seg.Words); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+		err = tx.QueryRowContext(ctx, `INSERT INTO transcript_segments (uuid, idx, start_time, end_time, text, words, speaker) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			msg.UUID, seg.Index, seg.Start, seg.End, seg.Text, words, nullString(seg.Speaker),
+		).Err()
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
 	return tx.Commit()
 }
 
 func (c *Client) AddSummarization(ctx context.Context, msg summarized.Record) error {
 	const op = "storage.postgres.addSummarization"
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
@@ -117,15 +143,83 @@ func (c *Client) AddSummarization(ctx context.Context, msg summarized.Record) er
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
 			return fmt.Errorf("%s: %w", op, storage.ErrUUIDExists)
+		} else if ok && pqErr.Code.Name() == "foreign_key_violation" {
+			return fmt.Errorf("%s: %w", op, storage.ErrUUIDNotFound)
 		}
 		return fmt.Errorf("%s: %w", op, err)
 	}
 	return tx.Commit()
 }
 
+// AddFrames replaces the slide keyframes of a file and of every duplicate of it. Duplicates are not
+// processed themselves, and their results may have been copied before the keyframes arrived.
+func (c *Client) AddFrames(ctx context.Context, msg frames.Record) error {
+	const op = "storage.postgres.addFrames"
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err = tx.QueryRowContext(ctx, `DELETE FROM frames WHERE uuid = $1`, msg.UUID).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	for _, f := range msg.Frames {
+		err = tx.QueryRowContext(ctx, `INSERT INTO frames (uuid, idx, timestamp, key) VALUES ($1, $2, $3, $4)`,
+			msg.UUID, f.Index, f.Timestamp, f.Key,
+		).Err()
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "foreign_key_violation" {
+				return fmt.Errorf("%s: %w", op, storage.ErrUUIDNotFound)
+			}
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if err = copyFrames(ctx, tx, msg.UUID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return tx.Commit()
+}
+
+// copyFrames replaces the slide keyframes of the duplicates of src, and of their duplicates, with those of src.
+func copyFrames(ctx context.Context, tx *sql.Tx, src string) error {
+	const dups = `WITH RECURSIVE dups (uuid) AS (
+			SELECT uuid FROM files WHERE duplicate_of = $1
+			UNION SELECT f.uuid FROM files f JOIN dups d ON f.duplicate_of = d.uuid) `
+	queries := []string{
+		dups + `DELETE FROM frames WHERE uuid IN (SELECT uuid FROM dups);`,
+		dups + `INSERT INTO frames (uuid, idx, timestamp, key) SELECT d.uuid, f.idx, f.timestamp, f.key FROM dups d, frames f WHERE f.uuid = $1;`,
+	}
+	for _, q := range queries {
+		if _, err := tx.ExecContext(ctx, q, src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddProgress stores msg as the progress of its file, unless a later event was stored already.
+func (c *Client) AddProgress(ctx context.Context, msg progress.Record) error {
+	const op = "storage.postgres.addProgress"
+	_, err := c.db.ExecContext(ctx, `INSERT INTO progress (uuid, stage, status, percent, eta, error, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (uuid) DO UPDATE SET stage = excluded.stage, status = excluded.status, percent = excluded.percent,
+			eta = excluded.eta, error = excluded.error, updated_at = excluded.updated_at
+		WHERE progress.updated_at <= excluded.updated_at;`,
+		msg.UUID, msg.Stage, msg.Status, msg.Percent, sql.NullFloat64{Float64: msg.ETA, Valid: msg.ETA > 0}, nullString(msg.Error), msg.At,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "foreign_key_violation" {
+			return fmt.Errorf("%s: %w", op, storage.ErrUUIDNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
 func (c *Client) UpdateFile(ctx context.Context, uuid string, status int) error {
 	const op = "storage.postgres.updateFile"
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
@@ -141,13 +235,13 @@ func (c *Client) UpdateFile(ctx context.Context, uuid string, status int) error
 	}
 
 	if id > status {
-		return fmt.Errorf("%s: %w", storage.ErrNewerStatus)
+		return fmt.Errorf("%s: %w", op, storage.ErrNewerStatus)
 	}
 
 	row := tx.QueryRowContext(ctx, `UPDATE files SET status = $1 WHERE uuid = $2`, status, uuid)
 	if err = row.Err(); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("%s: %w", storage.ErrUUIDNotFound)
+			return fmt.Errorf("%s: %w", op, storage.ErrUUIDNotFound)
 		}
 		return fmt.Errorf("%s: %w", op, err)
 	}
@@ -156,7 +250,7 @@ func (c *Client) UpdateFile(ctx context.Context, uuid string, status int) error
 
 func (c *Client) DeleteFile(ctx context.Context, uuid string) error {
 	const op = "storage.postgres.deleteFile"
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
@@ -164,7 +258,7 @@ func (c *Client) DeleteFile(ctx context.Context, uuid string) error {
 	row := tx.QueryRowContext(ctx, `DELETE FROM files WHERE uuid = $1`, uuid)
 	if err = row.Err(); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("%s: %w", storage.ErrUUIDNotFound)
+			return fmt.Errorf("%s: %w", op, storage.ErrUUIDNotFound)
 		}
 		return fmt.Errorf("%s: %w", op, err)
 	}
@@ -173,13 +267,17 @@ func (c *Client) DeleteFile(ctx context.Context, uuid string) error {
 
 func (c *Client) ListFiles(ctx context.Context, user_id uint) ([]frontend.File, error) {
 	const op = "storage.postgres.listFiles"
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 	defer tx.Rollback()
 
-	rows, err := tx.QueryContext(ctx, `SELECT og_filename, og_extension, uuid, status FROM files WHERE user_id = $1;`, user_id)
+	rows, err := tx.QueryContext(ctx, `SELECT f.og_filename, f.og_extension, f.uuid, f.status,
+		f.title, f.course, to_char(f.lecture_date, 'YYYY-MM-DD'), f.tags, f.language, r.purpose, r.key,
+		p.stage, p.status, p.percent, p.eta, p.error, p.updated_at
+		FROM files f LEFT JOIN renditions r ON r.uuid = f.uuid LEFT JOIN progress p ON p.uuid = f.uuid
+		WHERE f.user_id = $1 ORDER BY f.id;`, user_id)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
@@ -187,17 +285,35 @@ func (c *Client) ListFiles(ctx context.Context, user_id uint) ([]frontend.File,
 	var files []frontend.File
 	for rows.Next() {
 		var uuid, ext, name string
+		var title, course, date, language, purpose, key sql.NullString
+		var tags pq.StringArray
 		var status uint8
-		if err := rows.Scan(&name, &ext, &uuid, &status); err != nil {
+		var p nullProgress
+		if err := rows.Scan(&name, &ext, &uuid, &status, &title, &course, &date, &tags, &language, &purpose, &key,
+			&p.stage, &p.status, &p.percent, &p.eta, &p.error, &p.at); err != nil {
 			return nil, fmt.Errorf("%s: %w", op, err)
 		}
-		files = append(files, frontend.File{
-			UUID:     uuid,
-			Name:     name + ext,
-			URL:      "",
-			MimeType: uploaded.Extensions[ext],
-			Status:   status,
-		})
+		if len(files) == 0 || files[len(files)-1].UUID != uuid {
+			files = append(files, frontend.File{
+				UUID:        uuid,
+				Name:        name + ext,
+				URL:         "",
+				Status:      status,
+				Title:       title.String,
+				Course:      course.String,
+				LectureDate: date.String,
+				Tags:        tags,
+				Language:    language.String,
+				Renditions:  make(map[string]string),
+				Progress:    p.record(uuid),
+			})
+		}
+		if purpose.Valid {
+			files[len(files)-1].Renditions[purpose.String] = key.String
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 	if len(files) == 0 {
 		return nil, storage.ErrNoFiles
@@ -205,9 +321,202 @@ func (c *Client) ListFiles(ctx context.Context, user_id uint) ([]frontend.File,
 	return files, nil
 }
 
+// ListFrames returns the slide keyframes of a file owned by uid, in timestamp order.
+func (c *Client) ListFrames(ctx context.Context, uuid string, uid uint) ([]frontend.Frame, error) {
+	const op = "storage.postgres.listFrames"
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err = ownsFile(ctx, tx, uuid, uid); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT idx, timestamp, key FROM frames WHERE uuid = $1 ORDER BY idx;`, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	out := []frontend.Frame{}
+	for rows.Next() {
+		var f frontend.Frame
+		if err = rows.Scan(&f.Index, &f.Timestamp, &f.Key); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		out = append(out, f)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return out, nil
+}
+
+// ListSegments returns the timed transcript segments of a file owned by uid, in order.
+func (c *Client) ListSegments(ctx context.Context, uuid string, uid uint) ([]transcribed.Segment, error) {
+	const op = "storage.postgres.listSegments"
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err = ownsFile(ctx, tx, uuid, uid); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT idx, start_time, end_time, text, words, speaker FROM transcript_segments WHERE uuid = $1 ORDER BY idx;`, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	out := []transcribed.Segment{}
+	for rows.Next() {
+		var seg transcribed.Segment
+		var words []byte
+		var speaker sql.NullString
+		if err = rows.Scan(&seg.Index, &seg.Start, &seg.End, &seg.Text, &words, &speaker); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		seg.Speaker = speaker.String
+		if words != nil {
+			if err = json.Unmarshal(words, &seg.Words); err != nil {
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+		}
+		out = append(out, seg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return out, nil
+}
+
+// GetTranscript returns the original file name, without extension, and the transcript with its segments
+// of a file owned by uid, and the report of the quality checks. Speakers are named as the user renamed them. It fails with storage.ErrNotReady until the file is transcribed.
+func (c *Client) GetTranscript(ctx context.Context, uuid string, uid uint) (string, transcribed.Record, error) {
+	const op = "storage.postgres.getTranscript"
+	var name string
+	var text, language sql.NullString
+	var quality []byte
+	err := c.db.QueryRowContext(ctx, `SELECT f.og_filename, t.text, t.language, t.quality FROM files f LEFT JOIN transcribed t ON t.uuid = f.uuid
+		WHERE f.uuid = $1 AND f.user_id = $2;`, uuid, uid).Scan(&name, &text, &language, &quality)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", transcribed.Record{}, fmt.Errorf("%s: %w", op, storage.ErrUUIDNotFound)
+		}
+		return "", transcribed.Record{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if !text.Valid {
+		return "", transcribed.Record{}, fmt.Errorf("%s: %w", op, storage.ErrNotReady)
+	}
+	rec := transcribed.Record{UUID: uuid, Text: text.String, Language: language.String}
+	if quality != nil {
+		if err = json.Unmarshal(quality, &rec.Quality); err != nil {
+			return "", transcribed.Record{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	segments, err := c.ListSegments(ctx, uuid, uid)
+	if err != nil {
+		return "", transcribed.Record{}, fmt.Errorf("%s: %w", op, err)
+	}
+	speakers, err := c.ListSpeakers(ctx, uuid, uid)
+	if err != nil {
+		return "", transcribed.Record{}, fmt.Errorf("%s: %w", op, err)
+	}
+	names := make(map[string]string, len(speakers))
+	for _, s := range speakers {
+		names[s.Label] = s.Name
+	}
+	for i, s := range segments {
+		if name, ok := names[s.Speaker]; ok {
+			segments[i].Speaker = name
+		}
+	}
+	rec.Segments = segments
+	return name, rec, nil
+}
+
+// ListSpeakers returns the speaker labels of the transcript of a file owned by uid, in order of appearance,
+// with the names the user gave them. Unnamed speakers are named after their label.
+func (c *Client) ListSpeakers(ctx context.Context, uuid string, uid uint) ([]frontend.Speaker, error) {
+	const op = "storage.postgres.listSpeakers"
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err = ownsFile(ctx, tx, uuid, uid); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT s.speaker, COALESCE(n.name, s.speaker)
+		FROM (SELECT speaker, MIN(idx) AS first FROM transcript_segments WHERE uuid = $1 AND speaker IS NOT NULL GROUP BY speaker) s
+		LEFT JOIN speakers n ON n.uuid = $1 AND n.label = s.speaker
+		ORDER BY s.first;`, uuid)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	out := []frontend.Speaker{}
+	for rows.Next() {
+		var s frontend.Speaker
+		if err = rows.Scan(&s.Label, &s.Name); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		out = append(out, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return out, nil
+}
+
+// RenameSpeakers names the speaker labels of a file owned by uid. An empty name resets a speaker to its label.
+func (c *Client) RenameSpeakers(ctx context.Context, uuid string, uid uint, names map[string]string) error {
+	const op = "storage.postgres.renameSpeakers"
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err = ownsFile(ctx, tx, uuid, uid); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for label, name := range names {
+		var exists bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM transcript_segments WHERE uuid = $1 AND speaker = $2);`, uuid, label).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if !exists {
+			return fmt.Errorf("%s: %q: %w", op, label, storage.ErrSpeakerNotFound)
+		}
+
+		if name == "" {
+			_, err = tx.ExecContext(ctx, `DELETE FROM speakers WHERE uuid = $1 AND label = $2;`, uuid, label)
+		} else {
+			_, err = tx.ExecContext(ctx, `INSERT INTO speakers (uuid, label, name) VALUES ($1, $2, $3)
+				ON CONFLICT (uuid, label) DO UPDATE SET name = excluded.name;`, uuid, label, name)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	return tx.Commit()
+}
+
 func (c *Client) GetFileData(ctx context.Context, uuid string, uid uint) (string, error) {
 	const op = "storage.postgres.getFileData"
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err.Error(), fmt.Errorf("%s: %w", op, err)
 	}
@@ -223,6 +532,15 @@ func (c *Client) GetFileData(ctx context.Context, uuid string, uid uint) (string
 	}
 
 	if status == 0 {
+		var p nullProgress
+		err = tx.QueryRowContext(ctx, `SELECT stage, status, percent, eta, error, updated_at FROM progress WHERE uuid = $1;`, uuid).
+			Scan(&p.stage, &p.status, &p.percent, &p.eta, &p.error, &p.at)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err.Error(), fmt.Errorf("%s: %w", op, err)
+		}
+		if r := p.record(uuid); r != nil {
+			return fmt.Sprintf("Your file has not been processed yet. %s", r), nil
+		}
 		return "Your file has not been processed yet, please wait...", nil
 	} else if status == 1 {
 		var data string
@@ -240,3 +558,89 @@ func (c *Client) GetFileData(ctx context.Context, uuid string, uid uint) (string
 		return data, nil
 	}
 }
+
+// ownsFile returns storage.ErrUUIDNotFound unless the file exists and belongs to uid.
+func ownsFile(ctx context.Context, tx *sql.Tx, uuid string, uid uint) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM files WHERE uuid = $1 AND user_id = $2);`, uuid, uid).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return storage.ErrUUIDNotFound
+	}
+	return nil
+}
+
+// copyResults copies the transcript with its segments, the summary and the slide keyframes of src to dst
+// and moves dst to the status of src. Speaker names are copied only if both files belong to the same user.
+func copyResults(ctx context.Context, tx *sql.Tx, src, dst string) error {
+	queries := []string{
+		`INSERT INTO transcribed (uuid, text, language, settings, quality) SELECT $2, text, language, settings, quality FROM transcribed WHERE uuid = $1;`,
+		`INSERT INTO transcript_segments (uuid, idx, start_time, end_time, text, words, speaker)
+			SELECT $2, idx, start_time, end_time, text, words, speaker FROM transcript_segments WHERE uuid = $1;`,
+		// speaker names are what the uploader typed, so they are only shared between files of the same user
+		`INSERT INTO speakers (uuid, label, name) SELECT $2, s.label, s.name FROM speakers s
+			JOIN files src ON src.uuid = s.uuid JOIN files dst ON dst.uuid = $2
+			WHERE s.uuid = $1 AND src.user_id = dst.user_id;`,
+		`INSERT INTO summarized (uuid, text) SELECT $2, text FROM summarized WHERE uuid = $1;`,
+		`INSERT INTO frames (uuid, idx, timestamp, key) SELECT $2, idx, timestamp, key FROM frames WHERE uuid = $1;`,
+		`UPDATE files SET status = src.status FROM files src WHERE src.uuid = $1 AND files.uuid = $2;`,
+	}
+	for _, q := range queries {
+		if _, err := tx.ExecContext(ctx, q, src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindProcessed returns the UUID of the oldest fully processed file whose ASR audio has the given hash
+// and whose transcript matches the transcription hints of meta: the same language if one is given,
+// and the same translation and initial prompt.
+func (c *Client) FindProcessed(ctx context.Context, audioHash string, meta uploaded.Metadata) (string, bool, error) {
+	const op = "storage.postgres.findProcessed"
+	var uuid string
+	err := c.db.QueryRowContext(ctx, `SELECT f.uuid FROM files f JOIN transcribed t ON t.uuid = f.uuid
+                WHERE f.audio_hash = $1 AND f.status = 2
+                  AND ($2 = '' OR t.language = $2)
+                  AND COALESCE((t.settings->>'translate')::boolean, false) = $3
+                  AND COALESCE(t.settings->>'prompt', '') = $4
+                ORDER BY f.id LIMIT 1;`,
+		audioHash, meta.Language, meta.Translate, meta.InitialPrompt(),
+	).Scan(&uuid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("%s: %w", op, err)
+	}
+	return uuid, true, nil
+}
+
+// nullProgress scans a progress row that may be missing from a LEFT JOIN.
+type nullProgress struct {
+	stage, status, error sql.NullString
+	percent, eta         sql.NullFloat64
+	at                   sql.NullTime
+}
+
+func (p nullProgress) record(uuid string) *progress.Record {
+	if !p.stage.Valid {
+		return nil
+	}
+	return &progress.Record{
+		UUID:    uuid,
+		Stage:   progress.Stage(p.stage.String),
+		Status:  progress.Status(p.status.String),
+		Percent: p.percent.Float64,
+		ETA:     p.eta.Float64,
+		Error:   p.error.String,
+		At:      p.at.Time,
+	}
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -3,8 +3,10 @@ package storage
 import "errors"
 
 var (
-	ErrUUIDExists   = errors.New("UUID already exists")
-	ErrUUIDNotFound = errors.New("UUID not found")
-	ErrNewerStatus  = errors.New("the file has newer status")
-	ErrNoFiles      = errors.New("no files found")
+	ErrUUIDExists      = errors.New("UUID already exists")
+	ErrUUIDNotFound    = errors.New("UUID not found")
+	ErrNewerStatus     = errors.New("the file has newer status")
+	ErrNoFiles         = errors.New("no files found")
+	ErrNotReady        = errors.New("the file is not processed yet")
+	ErrSpeakerNotFound = errors.New("speaker not found")
 )
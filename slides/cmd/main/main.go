@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	config2 "github.com/kxddry/lectura/slides/internal/config"
+	"github.com/kxddry/lectura/slides/internal/extractor"
+	"github.com/kxddry/lectura/slides/internal/handlers"
+
+	// shared tools
+	"github.com/kxddry/lectura/shared/entities/frames"
+	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"github.com/kxddry/lectura/shared/utils/broker/kafka"
+	"github.com/kxddry/lectura/shared/utils/config"
+	"github.com/kxddry/lectura/shared/utils/logger"
+	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
+	"github.com/kxddry/lectura/shared/utils/s3"
+
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// config
+	var cfg config2.Config
+	config.MustParseConfig(&cfg)
+
+	// logger
+	log := logger.SetupLogger(cfg.Env)
+	log.Debug("debug enabled")
+
+	// S3 client
+	cli, err := s3.NewClient(cfg.S3Storage)
+	if err != nil {
+		log.Error("Error creating minio client", sl.Err(err))
+		os.Exit(1)
+	}
+
+	log.Debug("minio client created")
+
+	r := kafka.NewReader[uploaded.Record](cfg.Kafka.Read)
+	dl := kafka.NewDeadLetters(cfg.Kafka.Read.Brokers, "slides")
+	defer dl.Close()
+	r.OnGiveUp(kafka.ToDeadLetters[uploaded.Record](log, dl))
+	r.OnInvalid(kafka.InvalidToDeadLetters(log, dl))
+	if err = r.CheckAlive(); err != nil {
+		log.Error("CheckAlive failed", sl.Err(err))
+		os.Exit(1)
+	}
+	w, err := kafka.NewWriter[frames.Record](cfg.Kafka.Write)
+	if err != nil {
+		log.Error("invalid kafka writer config", sl.Err(err))
+		os.Exit(1)
+	}
+	log.Debug("kafka clients created")
+
+	ex := extractor.New(cfg.Frames)
+
+	// create a worker pool; ffmpeg is heavy, so it is small
+	jobs := make(chan kafka.Message[uploaded.Record], cfg.Workers)
+	results := make(chan error, cfg.Workers)
+
+	for i := 0; i < cfg.Workers; i++ {
+		go func(id int) {
+			for msg := range jobs {
+				log.Debug("processing msg", slog.String("UUID", msg.Value.UUID), slog.String("event_id", msg.Envelope.EventID), slog.Int("attempt", msg.Attempt))
+				err := handlers.Pipeline(kafka.WithCorrelationID(ctx, msg.Envelope.CorrelationID), cli, ex, w, msg.Value)
+				if err != nil {
+					log.Error("error processing job", sl.Err(err))
+					err = msg.Nack(err)
+				} else {
+					err = msg.Ack()
+				}
+				results <- err
+			}
+		}(i)
+	}
+	log.Debug("worker pool created")
+
+	go distributeJobs(ctx, log, r, jobs)
+	log.Debug("job handler started")
+
+	go processResults(log, results)
+	log.Debug("error handler started")
+
+	// graceful shutdown
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	log.Info("signal received, shutting down gracefully")
+}
+
+func distributeJobs[T uploaded.Record](ctx context.Context, log *slog.Logger, r kafka.Reader[T], jobs chan<- kafka.Message[T]) {
+	msgCh, errCh := r.Messages(ctx)
+	for {
+		select {
+		case msg, ok := <-msgCh:
+			if !ok {
+				close(jobs)
+				return
+			}
+			jobs <- msg
+		case err := <-errCh:
+			log.Error("kafka reader", sl.Err(err))
+			return
+		case <-ctx.Done():
+			log.Debug("distributor shutting down, ctx done")
+			close(jobs)
+			return
+		}
+	}
+}
+
+func processResults(log *slog.Logger, results <-chan error) {
+	for err := range results {
+		if err != nil {
+			log.Error("error popped up", sl.Err(err))
+		} else {
+			log.Debug("job processed successfully")
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"github.com/kxddry/lectura/shared/entities/config/kafka"
+	"github.com/kxddry/lectura/shared/entities/config/s3"
+	"time"
+)
+
+type Config struct {
+	Env       string           `yaml:"env" env-required:"true"`
+	S3Storage s3.StorageConfig `yaml:"s3storage" env-required:"true"`
+	Kafka     Kafka            `yaml:"kafka" env-required:"true"`
+	Workers   int              `yaml:"workers" env-default:"2"`
+	Frames    Frames           `yaml:"frames"`
+}
+
+type Kafka struct {
+	Brokers []string           `yaml:"brokers" env-required:"true"`
+	Read    kafka.ReaderConfig `yaml:"read" env-required:"true"`
+	Write   kafka.WriterConfig `yaml:"write" env-required:"true"`
+}
+
+// Frames configures slide keyframe extraction.
+type Frames struct {
+	SceneThreshold float64       `yaml:"scene_threshold" env-default:"0.3"` // ffmpeg scene change score, 0..1
+	MinInterval    time.Duration `yaml:"min_interval" env-default:"3s"`     // frames closer than this to the previous kept one are dropped
+	MaxDistance    int           `yaml:"max_distance" env-default:"6"`      // dHash bits; frames this close to a kept one are duplicates
+	MaxFrames      int           `yaml:"max_frames" env-default:"500"`
+	Width          int           `yaml:"width" env-default:"1280"` // frames wider than this are scaled down
+	Quality        int           `yaml:"quality" env-default:"3"`  // JPEG qscale, 2 (best) .. 31
+	Timeout        time.Duration `yaml:"timeout" env-default:"30m"`
+	Threads        int           `yaml:"threads" env-default:"2"`
+}
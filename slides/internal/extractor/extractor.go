@@ -0,0 +1,144 @@
+package extractor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/kxddry/lectura/slides/internal/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Keyframe is an extracted slide image on disk.
+type Keyframe struct {
+	Timestamp float64 // seconds from the start of the video
+	Path      string
+}
+
+// Extractor finds scene changes in a video with ffmpeg and keeps one image per distinct slide.
+type Extractor struct {
+	opts config.Frames
+}
+
+func New(opts config.Frames) Extractor {
+	return Extractor{opts: opts}
+}
+
+var showinfo = regexp.MustCompile(`\bn:\s*(\d+).*\bpts_time:([0-9.]+)`)
+
+// Extract writes the keyframes of the video at input into dir and returns them in timestamp order.
+// The first frame is always a candidate, every later one must start a new scene. Candidates that
+// come too soon after, or look the same as, an already kept frame are removed from dir.
+func (e Extractor) Extract(ctx context.Context, input, dir string) ([]Keyframe, error) {
+	const op = "extractor.Extract"
+	if e.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.opts.Timeout)
+		defer cancel()
+	}
+
+	filter := fmt.Sprintf("select='eq(n,0)+gt(scene,%g)',showinfo,scale='min(%d,iw)':-2", e.opts.SceneThreshold, e.opts.Width)
+	args := []string{"-hide_banner", "-nostdin", "-nostats"}
+	if e.opts.Threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(e.opts.Threads))
+	}
+	args = append(args,
+		"-i", input,
+		"-map", "0:v:0",
+		"-vf", filter,
+		"-fps_mode", "vfr",
+		"-q:v", strconv.Itoa(e.opts.Quality),
+		filepath.Join(dir, "%06d.jpg"),
+	)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// showinfo logs every selected frame in output order, so the i-th line belongs to the i-th image
+	var candidates []Keyframe
+	var last []string
+	sc := bufio.NewScanner(stderr)
+	for sc.Scan() {
+		line := sc.Text()
+		if last = append(last, line); len(last) > 20 {
+			last = last[1:]
+		}
+		if !strings.Contains(line, "Parsed_showinfo") {
+			continue
+		}
+		m := showinfo.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		ts, _ := strconv.ParseFloat(m[2], 64)
+		candidates = append(candidates, Keyframe{
+			Timestamp: ts,
+			Path:      filepath.Join(dir, fmt.Sprintf("%06d.jpg", len(candidates)+1)),
+		})
+	}
+	if err = cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("%s: ffmpeg: %w: %s", op, err, strings.Join(last, "\n"))
+	}
+
+	kept, err := e.dedup(candidates)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return kept, nil
+}
+
+// dedup drops candidates that are perceptually equal to a kept frame and deletes their files.
+// A candidate that comes within MinInterval of the previous kept frame replaces it: slide
+// transitions and builds trigger several scene changes in a row, the last one is the settled slide.
+func (e Extractor) dedup(candidates []Keyframe) ([]Keyframe, error) {
+	var kept []Keyframe
+	var hashes []uint64
+	for _, c := range candidates {
+		h, err := hashFile(c.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		if n := len(kept); n > 0 && c.Timestamp-kept[n-1].Timestamp < e.opts.MinInterval.Seconds() {
+			if err = remove(kept[n-1].Path); err != nil {
+				return nil, err
+			}
+			kept, hashes = kept[:n-1], hashes[:n-1]
+		}
+
+		drop := e.opts.MaxFrames > 0 && len(kept) >= e.opts.MaxFrames
+		for _, k := range hashes {
+			if distance(h, k) <= e.opts.MaxDistance {
+				drop = true
+				break
+			}
+		}
+		if drop {
+			if err = remove(c.Path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		kept = append(kept, c)
+		hashes = append(hashes, h)
+	}
+	return kept, nil
+}
+
+func remove(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
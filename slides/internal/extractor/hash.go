@@ -0,0 +1,65 @@
+package extractor
+
+import (
+	"image"
+	_ "image/jpeg"
+	"math/bits"
+	"os"
+)
+
+// hashFile returns the difference hash (dHash) of an image: the image is shrunk to 9x8 gray
+// cells and every bit tells whether a cell is brighter than its right neighbour. Re-encoded,
+// rescaled or slightly noisy copies of a slide differ in only a few bits.
+func hashFile(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0, err
+	}
+	return dhash(img), nil
+}
+
+func dhash(img image.Image) uint64 {
+	const w, h = 9, 8
+	b := img.Bounds()
+	var cells [h][w]float64
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(b.Min.X+(x+1)*b.Dx()/w, x0+1)
+			// sample a sparse grid, full averaging is not worth it for 64 bits
+			var sum, n float64
+			for yy := y0; yy < y1; yy += max((y1-y0)/8, 1) {
+				for xx := x0; xx < x1; xx += max((x1-x0)/8, 1) {
+					r, g, bl, _ := img.At(xx, yy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+					n++
+				}
+			}
+			cells[y][x] = sum / n
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if cells[y][x] > cells[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// distance is the number of differing bits between two hashes.
+func distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
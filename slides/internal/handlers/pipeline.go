@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/kxddry/lectura/shared/entities/frames"
+	"github.com/kxddry/lectura/shared/entities/manifest"
+	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"github.com/kxddry/lectura/slides/internal/extractor"
+	"gopkg.in/vansante/go-ffprobe.v2"
+	"io"
+	"os"
+	"strings"
+)
+
+type s3client interface {
+	Download(ctx context.Context, bucket string, key string) (io.ReadCloser, error)
+	Upload(ctx context.Context, bucket string, file uploaded.File) error
+}
+
+type FramesWriter interface {
+	Write(ctx context.Context, record frames.Record) error
+}
+
+// Pipeline extracts the slide keyframes of a video lecture, stores them next to its renditions
+// and publishes a frames.Record. Audio-only uploads and duplicates are skipped.
+func Pipeline(ctx context.Context, cli s3client, ex extractor.Extractor, w FramesWriter, msg uploaded.Record) error {
+	const op = "handlers.Pipeline"
+
+	// the updater copies the frames of the original recording
+	if msg.DuplicateOf != "" {
+		return nil
+	}
+
+	original, ok := originalVideo(msg)
+	if !ok {
+		return nil
+	}
+
+	input, err := download(ctx, cli, msg.Bucket, original.Key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer os.Remove(input)
+
+	// some containers registered as video (e.g. webm) often carry audio only
+	data, err := ffprobe.ProbeURL(ctx, input)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if data.FirstVideoStream() == nil {
+		return nil
+	}
+
+	dir, err := os.MkdirTemp("", msg.UUID+"-frames-*")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyframes, err := ex.Extract(ctx, input, dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	out := frames.Record{UUID: msg.UUID, Bucket: msg.Bucket, Frames: make([]frames.Frame, 0, len(keyframes))}
+	for i, kf := range keyframes {
+		key := frames.Key(msg.UUID, i)
+		if err = upload(ctx, cli, msg.Bucket, key, kf.Path); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		out.Frames = append(out.Frames, frames.Frame{Index: i, Timestamp: kf.Timestamp, Key: key})
+	}
+
+	if err = w.Write(ctx, out); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// originalVideo returns the original rendition if the upload is a video.
+// Legacy records without renditions only have the ASR audio.
+func originalVideo(msg uploaded.Record) (manifest.Rendition, bool) {
+	for _, r := range msg.Update.Renditions {
+		if r.Purpose == manifest.Original && strings.HasPrefix(r.MimeType, "video/") {
+			return r, true
+		}
+	}
+	return manifest.Rendition{}, false
+}
+
+// download copies an object into a temporary file, so ffmpeg can seek in it, and returns its path.
+func download(ctx context.Context, cli s3client, bucket, key string) (string, error) {
+	rc, err := cli.Download(ctx, bucket, key)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp("", "slides-*")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	if _, err = io.Copy(tmp, rc); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func upload(ctx context.Context, cli s3client, bucket, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return cli.Upload(ctx, bucket, image{key: key, r: f, size: st.Size()})
+}
+
+// image is a JPEG keyframe to be uploaded.
+type image struct {
+	key  string
+	r    io.Reader
+	size int64
+}
+
+func (i image) FullName() string { return i.key }
+func (i image) Data() io.Reader  { return i.r }
+func (i image) Size() int64      { return i.size }
+func (i image) MimeType() string { return "image/jpeg" }
@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
+	"github.com/kxddry/lectura/shared/entities/progress"
 	"github.com/kxddry/lectura/shared/entities/summarized"
 	"github.com/kxddry/lectura/shared/entities/transcribed"
 	"github.com/kxddry/lectura/shared/utils/broker/kafka"
 	"github.com/kxddry/lectura/shared/utils/config"
 	"github.com/kxddry/lectura/shared/utils/logger"
 	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
+	"github.com/kxddry/lectura/shared/utils/reporter"
 	config2 "github.com/kxddry/lectura/summarizer/internal/config"
 	"github.com/kxddry/lectura/summarizer/internal/handlers"
 	"github.com/kxddry/lectura/summarizer/internal/llm"
@@ -34,13 +36,39 @@ func main() {
 	}
 
 	r := kafka.NewReader[transcribed.Record](cfg.Kafka.Reader)
-	w := kafka.NewWriter[summarized.Record](cfg.Kafka.Writer)
+	dl := kafka.NewDeadLetters(cfg.Kafka.Reader.Brokers, "summarizer")
+	defer dl.Close()
+	pw, err := kafka.NewWriter[progress.Record](cfg.Kafka.Progress)
+	if err != nil {
+		log.Error("invalid kafka writer config", sl.Err(err))
+		os.Exit(1)
+	}
+	pw.OnDelivery(func(d kafka.Delivery) {
+		if d.Err != nil {
+			log.Warn("progress event lost", slog.String("UUID", d.Key), slog.String("event_id", d.EventID), sl.Err(d.Err))
+		}
+	})
+	defer pw.Close()
+	toDLQ := kafka.ToDeadLetters[transcribed.Record](log, dl)
+	r.OnGiveUp(func(m kafka.Message[transcribed.Record], err error) error {
+		if derr := toDLQ(m, err); derr != nil {
+			return derr
+		}
+		reporter.Fail(ctx, log, pw, m.Value.UUID, progress.Summarize, err)
+		return nil
+	})
+	r.OnInvalid(kafka.InvalidToDeadLetters(log, dl))
+	w, err := kafka.NewWriter[summarized.Record](cfg.Kafka.Writer)
+	if err != nil {
+		log.Error("invalid kafka writer config", sl.Err(err))
+		os.Exit(1)
+	}
 
 	kp := kafka.NewPipeline(r, w)
 
 	// create a worker pool
 
-	jobs := make(chan transcribed.Record, 100)
+	jobs := make(chan kafka.Message[transcribed.Record], 100)
 	results := make(chan error, 100)
 
 	for i := 0; i < workerPoolSize; i++ {
@@ -48,9 +76,12 @@ func main() {
 		// workers handling jobs
 		go func(id int) {
 			for msg := range jobs {
-				err := handlers.Pipeline(ctx, llm.OpenAI{&cfg}, kp, msg)
+				err := handlers.Pipeline(kafka.WithCorrelationID(ctx, msg.Envelope.CorrelationID), log, llm.OpenAI{Cfg: &cfg}, kp, pw, msg.Value)
 				if err != nil {
 					log.Error("error processing job", sl.Err(err))
+					err = msg.Nack(err)
+				} else {
+					err = msg.Ack()
 				}
 				results <- err
 			}
@@ -72,12 +103,16 @@ func main() {
 	log.Info("signal received, shutting down gracefully")
 }
 
-func distributeJobs[R transcribed.Record, W summarized.Record](ctx context.Context, log *slog.Logger, kp kafka.Pipeline[R, W], jobs chan<- R) {
+func distributeJobs[R transcribed.Record, W summarized.Record](ctx context.Context, log *slog.Logger, kp kafka.Pipeline[R, W], jobs chan<- kafka.Message[R]) {
 	msgCh, errCh := kp.R.Messages(ctx)
 	for {
 		// orchestrate
 		select {
-		case msg := <-msgCh:
+		case msg, ok := <-msgCh:
+			if !ok {
+				close(jobs)
+				return
+			}
 			jobs <- msg
 		case err := <-errCh:
 			log.Error("kafka reader", sl.Err(err))
@@ -18,6 +18,7 @@ type Summarizer struct {
 }
 
 type Kafka struct {
-	Reader kafka2.ReaderConfig `yaml:"reader" env-required:"true"`
-	Writer kafka2.WriterConfig `yaml:"writer" env-required:"true"`
+	Reader   kafka2.ReaderConfig `yaml:"reader" env-required:"true"`
+	Writer   kafka2.WriterConfig `yaml:"writer" env-required:"true"`
+	Progress kafka2.WriterConfig `yaml:"progress" env-required:"true"` // file.progress
 }
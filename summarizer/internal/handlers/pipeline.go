@@ -3,10 +3,13 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"github.com/kxddry/lectura/shared/entities/progress"
 	"github.com/kxddry/lectura/shared/entities/summarized"
 	"github.com/kxddry/lectura/shared/entities/transcribed"
 	kafka2 "github.com/kxddry/lectura/shared/utils/broker/kafka"
+	"github.com/kxddry/lectura/shared/utils/reporter"
 	"github.com/kxddry/lectura/summarizer/internal/entities"
+	"log/slog"
 )
 
 type MessageSender interface {
@@ -14,18 +17,22 @@ type MessageSender interface {
 }
 
 func Pipeline[R transcribed.Record, W summarized.Record](
-	ctx context.Context, sender MessageSender, kp kafka2.Pipeline[R, W], msg transcribed.Record) error {
+	ctx context.Context, log *slog.Logger, sender MessageSender, kp kafka2.Pipeline[R, W], pw reporter.Writer, msg transcribed.Record) (err error) {
 	const op = "handlers.Pipeline"
 
-	txt := msg.Text
+	rep := reporter.Start(ctx, log, pw, msg.UUID, progress.Summarize)
+	defer func() { rep.Finish(err) }()
+
+	// with speaker labels, "Speaker 1: ...", so the lecture can be told apart from questions and answers
+	txt := msg.Attributed()
 
 	resp, err := sender.SendMessage(txt, msg.Language)
 	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+		return kafka2.Classify("llm", fmt.Errorf("%s: %w", op, err))
 	}
 	outTxt := resp.Choices[0].Message.Content
 	if len(outTxt) == 0 {
-		return fmt.Errorf("%s: empty response", op)
+		return kafka2.Classify("llm", fmt.Errorf("%s: empty response", op))
 	}
 
 	record := summarized.Record{
@@ -35,7 +42,7 @@ func Pipeline[R transcribed.Record, W summarized.Record](
 
 	err = kp.W.Write(ctx, W(record))
 	if err != nil {
-		return fmt.Errorf("%s failed to write in kafka: %w", op, err)
+		return kafka2.Classify("kafka", fmt.Errorf("%s failed to write in kafka: %w", op, err))
 	}
 
 	return nil
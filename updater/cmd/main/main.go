@@ -2,9 +2,8 @@ package main
 
 import (
 	"context"
-	"github.com/kxddry/lectura/shared/entities/summarized"
-	"github.com/kxddry/lectura/shared/entities/transcribed"
-	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"fmt"
+	kafka2 "github.com/kxddry/lectura/shared/entities/config/kafka"
 	"github.com/kxddry/lectura/shared/utils/broker/kafka"
 	"github.com/kxddry/lectura/shared/utils/config"
 	"github.com/kxddry/lectura/shared/utils/logger"
@@ -16,6 +15,7 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
 	"syscall"
 )
 
@@ -29,8 +29,8 @@ func main() {
 	workerPoolSize := cfg.WorkerPoolSize
 	multi := cfg.WorkerPoolMultiplier
 
-	if len(cfg.KafkaTopics) != 3 {
-		panic("3 topics required: uploaded, asm, sum")
+	if len(cfg.Routes) == 0 {
+		panic("no routes configured")
 	}
 
 	log := logger.SetupLogger(cfg.Env)
@@ -42,25 +42,36 @@ func main() {
 	}
 	log.Debug("sql created")
 
-	jobs := make(chan any, workerPoolSize*multi)
+	jobs := make(chan job, workerPoolSize*multi)
 	results := make(chan error, workerPoolSize*multi)
 
 	for i := range workerPoolSize {
 		go func(id int) {
 			log.Debug("worker listening " + strconv.Itoa(id))
-			for msg := range jobs {
-				log.Info("msg received", msg)
-				err = handlers.ProcessMessage(ctx, msg, sql)
+			for j := range jobs {
+				log.Info("msg received", slog.Any("msg", j.value), slog.String("event_id", j.event.EventID),
+					slog.String("producer", j.event.Producer), slog.Int("attempt", j.attempt))
+				err := j.handle(ctx, sql)
 				if err != nil {
-					log.Error("error processing", sl.Err(err))
+					if kafka.IsEarly(err) {
+						log.Info("file not stored yet, waiting for it", sl.Err(err))
+					} else {
+						log.Error("error processing", sl.Err(err))
+					}
+					err = j.nack(err)
+				} else {
+					err = j.ack()
 				}
 				results <- err
 			}
 		}(i)
 	}
 
+	dl := kafka.NewDeadLetters(cfg.Kafka.Brokers, "updater")
+	defer dl.Close()
+
 	go processResults(ctx, log, results)
-	run(ctx, &cfg, log, jobs)
+	run(ctx, &cfg, log, dl, jobs)
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
@@ -68,40 +79,100 @@ func main() {
 	log.Info("signal received, shutting down gracefully")
 }
 
-func run(ctx context.Context, cfg *cc.Config, log *slog.Logger, jobs chan<- any) {
-	// reader 1
-	cfg1 := cfg.Kafka
-	cfg1.Topic = cfg.KafkaTopics[0]
-	r1 := kafka.NewReader[uploaded.Record](cfg1)
-
-	// reader 2
-	cfg2 := cfg.Kafka
-	cfg2.Topic = cfg.KafkaTopics[1]
-	r2 := kafka.NewReader[transcribed.Record](cfg2)
-
-	// reader 3
-	cfg3 := cfg.Kafka
-	cfg3.Topic = cfg.KafkaTopics[2]
-	r3 := kafka.NewReader[summarized.Record](cfg3)
-
-	go handleJobs(ctx, log, r1, jobs)
-	go handleJobs(ctx, log, r2, jobs)
-	go handleJobs(ctx, log, r3, jobs)
+// job is a message of any of the topics with its handler and acknowledgement.
+type job struct {
+	value   any
+	event   kafka.Envelope
+	attempt int
+	handle  func(ctx context.Context, s handlers.Storage) error
+	ack     func() error
+	nack    func(error) error
+}
+
+// consumer reads the records of a route and queues them for its handler.
+type consumer struct {
+	typ  string // event type the handler takes
+	read func(ctx context.Context, log *slog.Logger, cfg kafka2.ReaderConfig, dl *kafka.DeadLetters, jobs chan<- job)
+}
+
+// consumers are the handlers routes can use, by name. A new record type needs to be registered
+// with kafka.Register and get a handler here.
+var consumers = map[string]consumer{
+	"file":       consume(handlers.File),
+	"transcript": consume(handlers.Transcript),
+	"summary":    consume(handlers.Summary),
+	"frames":     consume(handlers.Frames),
+	"progress":   consume(handlers.Progress),
+}
+
+func consume[T any](handle func(ctx context.Context, s handlers.Storage, msg T) error) consumer {
+	typ, ok := kafka.EventType[T]()
+	if !ok {
+		panic(fmt.Sprintf("handler of %T: type is not registered", *new(T)))
+	}
+	return consumer{typ: typ, read: func(ctx context.Context, log *slog.Logger, cfg kafka2.ReaderConfig, dl *kafka.DeadLetters, jobs chan<- job) {
+		r := kafka.NewReader[T](cfg)
+		r.OnGiveUp(kafka.ToDeadLetters[T](log, dl))
+		r.OnInvalid(kafka.InvalidToDeadLetters(log, dl))
+		handleJobs(ctx, log, r, handle, jobs)
+	}}
+}
+
+// run starts a reader for every route of the config. jobs is closed once all of them stopped.
+func run(ctx context.Context, cfg *cc.Config, log *slog.Logger, dl *kafka.DeadLetters, jobs chan<- job) {
+	var wg sync.WaitGroup
+	topics := make(map[string]bool, len(cfg.Routes))
+	for _, route := range cfg.Routes {
+		c, ok := consumers[route.Handler]
+		switch {
+		case !ok:
+			panic("route of " + route.Topic + ": unknown handler " + route.Handler)
+		case c.typ != route.Type:
+			panic("route of " + route.Topic + ": handler " + route.Handler + " takes " + c.typ + ", not " + route.Type)
+		case topics[route.Topic]:
+			// readers of the same group would split the partitions of the topic between them
+			panic("more than one route of " + route.Topic)
+		}
+		topics[route.Topic] = true
+
+		rcfg := cfg.Kafka
+		rcfg.Topic = route.Topic
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.read(ctx, log.With(slog.String("topic", route.Topic)), rcfg, dl, jobs)
+		}()
+		log.Debug("route started", slog.String("topic", route.Topic), slog.String("type", route.Type), slog.String("handler", route.Handler))
+	}
+	// every reader sends to jobs, so it is closed only after the last one stopped
+	go func() {
+		wg.Wait()
+		close(jobs)
+	}()
 }
 
-func handleJobs[T uploaded.Record | transcribed.Record | summarized.Record](
-	ctx context.Context, log *slog.Logger, r kafka.Reader[T], jobs chan<- any) {
+func handleJobs[T any](ctx context.Context, log *slog.Logger, r kafka.Reader[T],
+	handle func(ctx context.Context, s handlers.Storage, msg T) error, jobs chan<- job) {
 	msgCh, errCh := r.Messages(ctx)
 	for {
 		select {
-		case msg := <-msgCh:
-			jobs <- msg
+		case msg, ok := <-msgCh:
+			if !ok {
+				return
+			}
+			jobs <- job{
+				value:   msg.Value,
+				event:   msg.Envelope,
+				attempt: msg.Attempt,
+				handle:  func(ctx context.Context, s handlers.Storage) error { return handle(ctx, s, msg.Value) },
+				ack:     msg.Ack,
+				nack:    msg.Nack,
+			}
 		case err := <-errCh:
 			log.Error("kafka reader", sl.Err(err))
 			return
 		case <-ctx.Done():
 			log.Debug("ctx done")
-			close(jobs)
 			return
 		}
 	}
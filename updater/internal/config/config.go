@@ -7,9 +7,16 @@ import (
 
 type Config struct {
 	Env                  string             `yaml:"env" env-default:"prod"`
-	Kafka                kafka.ReaderConfig `yaml:"kafka" env-required:"true"`
+	Kafka                kafka.ReaderConfig `yaml:"kafka" env-required:"true"` // shared by the readers of all routes
 	Storage              db.StorageConfig   `yaml:"storage" env-required:"true"`
-	KafkaTopics          []string           `yaml:"kafka_topics" env-required:"true"`
+	Routes               []Route            `yaml:"routes" env-required:"true"`
 	WorkerPoolSize       int                `yaml:"worker_pool_size" env-default:"2"`
 	WorkerPoolMultiplier int                `yaml:"worker_pool_multiplier" env-default:"2"`
 }
+
+// Route hands the records of event type Type read from Topic to the handler called Handler.
+type Route struct {
+	Topic   string `yaml:"topic"`
+	Type    string `yaml:"type"`    // e.g. file.uploaded
+	Handler string `yaml:"handler"` // file | transcript | summary | frames | progress
+}
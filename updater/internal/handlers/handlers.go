@@ -4,15 +4,21 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/kxddry/lectura/shared/entities/frames"
+	"github.com/kxddry/lectura/shared/entities/progress"
 	"github.com/kxddry/lectura/shared/entities/summarized"
 	"github.com/kxddry/lectura/shared/entities/transcribed"
 	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"github.com/kxddry/lectura/shared/utils/broker/kafka"
+	"github.com/kxddry/lectura/shared/utils/storage"
 )
 
 type Storage interface {
 	AddFile(ctx context.Context, msg uploaded.Record) error
 	AddTranscription(ctx context.Context, msg transcribed.Record) error
 	AddSummarization(ctx context.Context, msg summarized.Record) error
+	AddFrames(ctx context.Context, msg frames.Record) error
+	AddProgress(ctx context.Context, msg progress.Record) error
 	UpdateFile(ctx context.Context, uuid string, status int) error
 }
 
@@ -22,32 +28,63 @@ const (
 	summarize
 )
 
-func ProcessMessage(ctx context.Context, msg any, s Storage) error {
-	const op = "handlers.ProcessMessage"
-	var err error
-	switch msg.(type) {
-	case uploaded.Record:
-		err = s.AddFile(ctx, msg.(uploaded.Record))
-		if err != nil {
-			return fmt.Errorf("%s: %w", op, err)
-		}
-	case transcribed.Record:
-		msgg := msg.(transcribed.Record)
-		err = s.AddTranscription(ctx, msgg)
-		if err != nil {
-			return fmt.Errorf("%s: %w", op, err)
-		}
-		_ = s.UpdateFile(ctx, msgg.UUID, transcribe)
-	case summarized.Record:
-		msgg := msg.(summarized.Record)
-		err = s.AddSummarization(ctx, msgg)
-		if err != nil {
-			return fmt.Errorf("%s: %w", op, err)
-		}
-		_ = s.UpdateFile(ctx, msgg.UUID, summarize)
-
-	default:
-		panic(fmt.Errorf("%s: %w", op, errors.New("unsupported message type")))
+// messages are delivered at least once: a record that exists already was stored by an earlier delivery.
+// Topics are not ordered among each other, so a result can arrive before its file is stored;
+// it is nacked with kafka.Early and redelivered until the file is there. The status is only moved forward:
+// a status that is already further along is kept, any other failure to update it is retried.
+
+// early marks an error of a result whose file is not stored yet.
+func early(err error) error {
+	if errors.Is(err, storage.ErrUUIDNotFound) {
+		return kafka.Early(err)
+	}
+	return err
+}
+
+func File(ctx context.Context, s Storage, msg uploaded.Record) error {
+	const op = "handlers.File"
+	if err := s.AddFile(ctx, msg); err != nil && !errors.Is(err, storage.ErrUUIDExists) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func Transcript(ctx context.Context, s Storage, msg transcribed.Record) error {
+	const op = "handlers.Transcript"
+	if err := s.AddTranscription(ctx, msg); err != nil && !errors.Is(err, storage.ErrUUIDExists) {
+		return early(fmt.Errorf("%s: %w", op, err))
+	}
+	if err := s.UpdateFile(ctx, msg.UUID, transcribe); err != nil && !errors.Is(err, storage.ErrNewerStatus) {
+		return early(fmt.Errorf("%s: %w", op, err))
+	}
+	return nil
+}
+
+func Summary(ctx context.Context, s Storage, msg summarized.Record) error {
+	const op = "handlers.Summary"
+	if err := s.AddSummarization(ctx, msg); err != nil && !errors.Is(err, storage.ErrUUIDExists) {
+		return early(fmt.Errorf("%s: %w", op, err))
+	}
+	if err := s.UpdateFile(ctx, msg.UUID, summarize); err != nil && !errors.Is(err, storage.ErrNewerStatus) {
+		return early(fmt.Errorf("%s: %w", op, err))
+	}
+	return nil
+}
+
+// Frames does not change the status: frames may arrive before or after the transcript.
+func Frames(ctx context.Context, s Storage, msg frames.Record) error {
+	const op = "handlers.Frames"
+	if err := s.AddFrames(ctx, msg); err != nil {
+		return early(fmt.Errorf("%s: %w", op, err))
+	}
+	return nil
+}
+
+// Progress is best effort: the file may not be stored yet, or was deleted meanwhile.
+func Progress(ctx context.Context, s Storage, msg progress.Record) error {
+	const op = "handlers.Progress"
+	if err := s.AddProgress(ctx, msg); err != nil && !errors.Is(err, storage.ErrUUIDNotFound) {
+		return fmt.Errorf("%s: %w", op, err)
 	}
 	return nil
 }
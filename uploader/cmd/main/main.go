@@ -7,12 +7,17 @@ import (
 	"github.com/kxddry/lectura/shared/utils/broker/kafka"
 	"github.com/kxddry/lectura/shared/utils/config"
 	"github.com/kxddry/lectura/shared/utils/ed25519"
+	"github.com/kxddry/lectura/shared/utils/formats"
 	"github.com/kxddry/lectura/shared/utils/logger"
 	"github.com/kxddry/lectura/shared/utils/logger/handlers/sl"
 	middleware2 "github.com/kxddry/lectura/shared/utils/middleware"
 	"github.com/kxddry/lectura/shared/utils/s3"
+	"github.com/kxddry/lectura/shared/utils/storage/postgres"
 	cc "github.com/kxddry/lectura/uploader/internal/config"
 	"github.com/kxddry/lectura/uploader/internal/handlers"
+	"github.com/kxddry/lectura/uploader/internal/jobs"
+	"github.com/kxddry/lectura/uploader/internal/sessions"
+	"github.com/kxddry/lectura/uploader/pkg/helpers/converter"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"golang.org/x/time/rate"
@@ -75,7 +80,11 @@ func main() {
 	pubKeyMap[keyId] = *pubkey
 
 	// init kafka writer
-	w := kafka.NewWriter[uploaded.Record](cfg.Kafka)
+	w, err := kafka.NewWriter[uploaded.Record](cfg.Kafka)
+	if err != nil {
+		log.Error("invalid kafka writer config", sl.Err(err))
+		os.Exit(1)
+	}
 
 	// init router
 	e := echo.New()
@@ -83,8 +92,9 @@ func main() {
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"POST"},
-		AllowHeaders:     []string{"Content-Type"},
+		AllowMethods:     []string{"GET", "POST", "PATCH", "HEAD", "DELETE"},
+		AllowHeaders:     []string{"Content-Type", handlers.HeaderUploadLength, handlers.HeaderUploadOffset},
+		ExposeHeaders:    []string{"Location", handlers.HeaderUploadLength, handlers.HeaderUploadOffset, handlers.HeaderUploadExpires},
 		AllowCredentials: true,
 	}))
 	e.Use(middleware2.JWTMiddleware(middleware2.JWTMiddlewareConfig{
@@ -102,7 +112,72 @@ func main() {
 
 	e.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(rate.Limit(cfg.RateLimit))))
 
-	e.POST("/api/v1/upload", handlers.UploadHandler(ctx, log, w, s3Client, bucket, "access_token"))
+	reg, err := formats.Load(cfg.Formats)
+	if err != nil {
+		log.Error("Invalid formats config", sl.Err(err))
+		os.Exit(1)
+	}
+
+	in := handlers.Ingester{
+		Log:    log,
+		Writer: w,
+		Client: s3Client,
+		Conv: converter.New(converter.Options{
+			Timeout:    cfg.FFmpeg.Timeout,
+			Threads:    cfg.FFmpeg.Threads,
+			MaxMemory:  cfg.FFmpeg.MaxMemory,
+			Preprocess: converter.Preprocess(cfg.Preprocess),
+		}),
+		Formats: reg,
+		Bucket:  bucket,
+	}
+
+	if cfg.Dedup.Enabled {
+		index, err := postgres.New(cfg.Storage)
+		if err != nil {
+			log.Error("Failed to connect to postgres", sl.Err(err))
+			os.Exit(1)
+		}
+		defer index.Close()
+		in.Index = index
+	}
+
+	// asynchronous ingestion: uploads are answered with 202 and processed by the pool
+	jc := cfg.Jobs
+	js := jobs.NewStore(s3Client, bucket, jc.Prefix)
+	pool := jobs.NewPool(log, js, in.Process(js), jc.QueueSize)
+	pool.Start(ctx, jc.Workers)
+	go js.RunCleanup(ctx, jc.CleanupInterval, jc.TTL, func(err error) {
+		log.Error("failed to clean up ingest jobs", sl.Err(err))
+	})
+	j := handlers.Jobs{
+		Log:    log,
+		Client: s3Client,
+		Bucket: bucket,
+		Store:  js,
+		Queue:  pool,
+	}
+
+	e.POST("/api/v1/upload", handlers.UploadHandler(ctx, log, j, "access_token"))
+	e.GET("/api/v1/upload/jobs/:id", handlers.JobStatus(ctx, log, js))
+
+	// resumable uploads
+	rc := cfg.Resumable
+	st := sessions.New(s3Client, bucket, rc.Prefix, rc.SessionTTL)
+	go st.RunCleanup(ctx, rc.CleanupInterval, func(err error) {
+		log.Error("failed to clean up upload sessions", sl.Err(err))
+	})
+
+	e.POST("/api/v1/upload/sessions", handlers.CreateSession(ctx, log, st, rc.MaxSize))
+	e.HEAD("/api/v1/upload/sessions/:id", handlers.SessionOffset(ctx, log, st))
+	e.PATCH("/api/v1/upload/sessions/:id", handlers.PatchSession(ctx, log, st, rc.MaxChunkSize))
+	e.POST("/api/v1/upload/sessions/:id/finalize", handlers.FinalizeSession(ctx, log, j, st))
+	e.DELETE("/api/v1/upload/sessions/:id", handlers.DeleteSession(ctx, log, st))
+
+	// direct-to-S3 uploads
+	dc := cfg.Direct
+	e.POST("/api/v1/upload/presign", handlers.PresignUpload(ctx, log, s3Client, reg, bucket, dc.Prefix, dc.MaxSize, dc.Expiry))
+	e.POST("/api/v1/upload/complete", handlers.CompleteUpload(ctx, log, j, s3Client, dc.Prefix, dc.MaxSize))
 
 	log.Info("Server started at " + cfg.Server.Address)
 	e.Logger.Fatal(e.Start(cfg.Server.Address))
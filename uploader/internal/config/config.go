@@ -3,9 +3,12 @@ package config
 import (
 	"github.com/kxddry/lectura/shared/entities/auth"
 	"github.com/kxddry/lectura/shared/entities/config/app"
+	"github.com/kxddry/lectura/shared/entities/config/db"
 	"github.com/kxddry/lectura/shared/entities/config/kafka"
 	"github.com/kxddry/lectura/shared/entities/config/s3"
 	"github.com/kxddry/lectura/shared/entities/config/services"
+	"github.com/kxddry/lectura/shared/utils/formats"
+	"time"
 )
 
 type Config struct {
@@ -19,6 +22,64 @@ type Config struct {
 	PublicKeys  []auth.PublicKeyEntry `yaml:"public_keys" env-required:"true"`
 	App         app.App               `yaml:"app" env-required:"true"`
 	RateLimit   int64                 `yaml:"rate_limit" env-required:"true"`
+	Resumable   Resumable             `yaml:"resumable"`
+	Direct      Direct                `yaml:"direct"`
+	FFmpeg      FFmpeg                `yaml:"ffmpeg"`
+	Preprocess  Preprocessing         `yaml:"preprocessing"`
+	Jobs        Jobs                  `yaml:"jobs"`
+	Formats     []formats.Format      `yaml:"formats"` // formats.Default if empty
+	Dedup       Dedup                 `yaml:"dedup"`
+	Storage     db.StorageConfig      `yaml:"storage"` // required if dedup is enabled
+}
+
+// Dedup reuses the results of identical recordings; clients can opt out per upload with dedup=false.
+type Dedup struct {
+	Enabled bool `yaml:"enabled" env-default:"false"`
+}
+
+// Jobs configures asynchronous ingestion of uploaded files.
+type Jobs struct {
+	Prefix          string        `yaml:"prefix" env-default:"jobs"`   // S3 prefix for jobs and raw uploads
+	Workers         int           `yaml:"workers" env-default:"4"`     // concurrent probe/convert/publish runs
+	QueueSize       int           `yaml:"queue_size" env-default:"64"` // further uploads are rejected with 503
+	TTL             time.Duration `yaml:"ttl" env-default:"24h"`       // finished jobs are kept this long
+	CleanupInterval time.Duration `yaml:"cleanup_interval" env-default:"10m"`
+}
+
+// FFmpeg limits every conversion process.
+type FFmpeg struct {
+	Timeout   time.Duration `yaml:"timeout" env-default:"30m"`
+	Threads   int           `yaml:"threads" env-default:"2"`
+	MaxMemory int64         `yaml:"max_memory" env-default:"2147483648"` // 2GB address space, 0 = unlimited
+}
+
+// Preprocessing toggles the filters applied to the ASR audio.
+type Preprocessing struct {
+	Loudnorm         bool          `yaml:"loudnorm" env-default:"true"`
+	TargetLUFS       float64       `yaml:"target_lufs" env-default:"-16"`
+	TrimSilence      bool          `yaml:"trim_silence" env-default:"true"`
+	NoiseReduction   bool          `yaml:"noise_reduction" env-default:"false"`
+	RemovePauses     bool          `yaml:"remove_pauses" env-default:"false"`
+	MaxPause         time.Duration `yaml:"max_pause" env-default:"3s"`
+	KeepPause        time.Duration `yaml:"keep_pause" env-default:"1s"`
+	SilenceThreshold float64       `yaml:"silence_threshold" env-default:"-35"` // dBFS
+	MinSilence       time.Duration `yaml:"min_silence" env-default:"500ms"`
+}
+
+// Direct configures browser-to-S3 uploads with presigned POST policies.
+type Direct struct {
+	Prefix  string        `yaml:"prefix" env-default:"direct"`       // S3 prefix for raw uploads
+	MaxSize int64         `yaml:"max_size" env-default:"1073741824"` // 1GB
+	Expiry  time.Duration `yaml:"expiry" env-default:"1h"`           // presigned policy lifetime
+}
+
+// Resumable configures chunked, resumable uploads.
+type Resumable struct {
+	Prefix          string        `yaml:"prefix" env-default:"uploads"`          // S3 prefix for sessions and chunks
+	MaxSize         int64         `yaml:"max_size" env-default:"1073741824"`     // 1GB, same as nginx
+	MaxChunkSize    int64         `yaml:"max_chunk_size" env-default:"67108864"` // 64MB
+	SessionTTL      time.Duration `yaml:"session_ttl" env-default:"24h"`         // extended by every chunk
+	CleanupInterval time.Duration `yaml:"cleanup_interval" env-default:"10m"`
 }
 
 type Clients struct {
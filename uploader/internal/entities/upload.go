@@ -39,3 +39,14 @@ func (f File) MimeType() string {
 func (f File) Close() error {
 	return f._data.Close()
 }
+
+// Rename returns a copy of the file stored under key, which includes the extension.
+func (f File) Rename(key string) File {
+	f._uuid, f._extension = key, ""
+	return f
+}
+
+// Extension returns the extension of the file, e.g. ".wav".
+func (f File) Extension() string {
+	return f._extension
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kxddry/go-utils/pkg/logger/handlers/sl"
+	"github.com/kxddry/lectura/shared/utils/formats"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// DirectClient lets the browser upload straight to S3 with a presigned POST policy.
+type DirectClient interface {
+	Client
+	GetPresignedPostPolicy(ctx context.Context, bucket, objectName, contentType string, maxSize int64, expiry time.Duration) (string, map[string]string, error)
+	Stat(ctx context.Context, bucket, key string) (int64, string, error)
+	Delete(ctx context.Context, bucket string, key string) error
+}
+
+type PresignRequest struct {
+	Filename    string `json:"filename" form:"filename"`
+	ContentType string `json:"content_type" form:"content_type"`
+	Size        int64  `json:"size" form:"size"`
+}
+
+type PresignResponse struct {
+	UploadID  string            `json:"upload_id"`
+	URL       string            `json:"url"`
+	Fields    map[string]string `json:"fields"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+type CompleteRequest struct {
+	UploadID string `json:"upload_id" form:"upload_id"`
+	Filename string `json:"filename" form:"filename"`
+	MetadataRequest
+}
+
+// PresignUpload issues a presigned POST policy restricted to the declared content type and maxSize.
+// After the browser has uploaded the file, it must call CompleteUpload.
+func PresignUpload(ctx context.Context, log *slog.Logger, cli DirectClient, reg *formats.Registry, bucket, prefix string, maxSize int64, expiry time.Duration) echo.HandlerFunc {
+	const op = "handlers.PresignUpload"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, err := userID(c)
+		if err != nil {
+			return err
+		}
+
+		var req PresignRequest
+		if err = c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+		}
+		if req.Filename == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "filename is required")
+		}
+		format, ok := reg.ByMime(req.ContentType)
+		if !ok {
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, "unsupported media type", req.ContentType)
+		}
+		if req.Size <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "size is required")
+		}
+		limit := maxSize
+		if format.MaxSize > 0 {
+			limit = min(limit, format.MaxSize)
+		}
+		if req.Size > limit {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "file too large")
+		}
+
+		uploadID := uuid.New().String()
+		url, fields, err := cli.GetPresignedPostPolicy(ctx, bucket, directKey(prefix, uid, uploadID), req.ContentType, limit, expiry)
+		if err != nil {
+			log.Error("failed to presign upload", sl.Err(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to presign upload")
+		}
+
+		return c.JSON(http.StatusOK, PresignResponse{
+			UploadID:  uploadID,
+			URL:       url,
+			Fields:    fields,
+			ExpiresAt: time.Now().UTC().Add(expiry),
+		})
+	}
+}
+
+// CompleteUpload verifies an object uploaded with a presigned policy and queues it
+// for ingestion. The raw object is removed once the job is done.
+func CompleteUpload(ctx context.Context, log *slog.Logger, j Jobs, cli DirectClient, prefix string, maxSize int64) echo.HandlerFunc {
+	const op = "handlers.CompleteUpload"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, err := userID(c)
+		if err != nil {
+			return err
+		}
+
+		var req CompleteRequest
+		if err = c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+		}
+		if _, err = uuid.Parse(req.UploadID); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid upload_id")
+		}
+		if req.Filename == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "filename is required")
+		}
+		meta, err := req.Validate()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		key := directKey(prefix, uid, req.UploadID)
+		size, _, err := cli.Stat(ctx, j.Bucket, key)
+		if err != nil {
+			log.Info("uploaded object not found", slog.String("key", key), sl.Err(err))
+			return echo.NewHTTPError(http.StatusNotFound, "upload not found")
+		}
+
+		if size > maxSize {
+			if err = cli.Delete(ctx, j.Bucket, key); err != nil {
+				log.Warn("failed to delete raw upload", slog.String("key", key), sl.Err(err))
+			}
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "file too large")
+		}
+
+		// the object is already in S3 and becomes the raw upload of the job as is
+		job := j.Store.NewJob(uid, req.Filename, meta, size)
+		job.RawKey = key
+		job.Dedup = req.DedupEnabled()
+		return j.Enqueue(ctx, c, job, nil)
+	}
+}
+
+func directKey(prefix string, uid uint, uploadID string) string {
+	return fmt.Sprintf("%s/%d/%s", prefix, uid, uploadID)
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/google/uuid"
+	"github.com/kxddry/go-utils/pkg/logger/handlers/sl"
+	"github.com/kxddry/lectura/shared/entities/manifest"
+	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"github.com/kxddry/lectura/shared/utils/formats"
+	"github.com/kxddry/lectura/uploader/internal/entities"
+	"github.com/kxddry/lectura/uploader/pkg/helpers/converter"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/vansante/go-ffprobe.v2"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+)
+
+// Ingester holds everything needed to turn an uploaded file into a published uploaded.Record.
+// All upload flows (multipart, resumable, direct) end in Ingest.
+type Ingester struct {
+	Log     *slog.Logger
+	Writer  KafkaWriter
+	Client  Client
+	Conv    converter.Converter
+	Formats *formats.Registry
+	Index   HashIndex // nil disables deduplication
+	Bucket  string
+}
+
+// HashIndex finds processed files by the hash of their ASR audio and the transcription hints of the upload.
+type HashIndex interface {
+	FindProcessed(ctx context.Context, audioHash string, meta uploaded.Metadata) (string, bool, error)
+}
+
+// Upload is a received file waiting to be ingested.
+type Upload struct {
+	UUID     string // file UUID, generated when empty
+	UserID   uint
+	Filename string
+	Metadata uploaded.Metadata
+	Dedup    bool // look for an identical, already processed recording
+	File     io.ReadSeekCloser
+	Size     int64
+}
+
+// Ingest validates an uploaded file, stores the original and its renditions in S3
+// and publishes the uploaded.Record to Kafka. It returns the file UUID.
+// ffmpeg is killed when ctx is cancelled.
+// Returned errors are *echo.HTTPError and can be passed straight back to echo.
+func (in Ingester) Ingest(ctx context.Context, up Upload) (string, error) {
+	const op = "handlers.Ingest"
+	log := in.Log.With(slog.String("op", op))
+	cli, bucket := in.Client, in.Bucket
+	file, size := up.File, up.Size
+
+	mtype, err := mimetype.DetectReader(file)
+
+	// failed to detect mimetype
+	if err != nil {
+		log.Error("failed to detect mimetype", sl.Err(err))
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "failed to detect mimetype", err)
+	}
+
+	// check mimetype
+	format, ok := detectFormat(in.Formats, mtype)
+	if !ok {
+		return "", echo.NewHTTPError(http.StatusUnsupportedMediaType, "unsupported media type", mtype.String())
+	}
+	ext := format.Extension
+	if format.MaxSize > 0 && size > format.MaxSize {
+		log.Info("file too large", slog.String("format", format.Name))
+		return "", echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("file too large, max allowed for %s is %d bytes", format.Name, format.MaxSize))
+	}
+
+	// go back to the start of the file
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		log.Error("failed to seek file", sl.Err(err))
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "internal server error", err)
+	}
+
+	data, err := ffprobe.ProbeReader(ctx, file)
+	if err != nil {
+		log.Error("failed to probe", sl.Err(err))
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "failed to probe", err)
+	}
+	_, _ = file.Seek(0, io.SeekStart)
+	dur := data.Format.DurationSeconds
+	// containers like webm are registered as video but often carry audio only
+	if format.Video && data.FirstVideoStream() == nil {
+		format.Video = false
+	}
+
+	if format.MaxDuration > 0 && dur > format.MaxDuration.Seconds() {
+		log.Info("audio too long", slog.String("format", format.Name))
+		return "", echo.NewHTTPError(http.StatusRequestEntityTooLarge, "audio too long, max allowed "+format.MaxDuration.String())
+	}
+
+	withoutExt := up.Filename[:len(up.Filename)-len(filepath.Ext(up.Filename))]
+	// UUIDv4 file name for storage
+	fileID := up.UUID
+	if fileID == "" {
+		fileID = uuid.New().String()
+	}
+	fc := entities.New(fileID, ext, file, size, format.MimeType())
+
+	// Keep the original file
+	original, err := storeRendition(ctx, cli, bucket, manifest.Original, fc.Rename(manifest.RenditionKey(fileID, manifest.Original, ext)))
+	if err != nil {
+		log.Error("failed to upload original file", sl.Err(err))
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload file: "+err.Error())
+	}
+	log.Info("Uploaded original file", slog.String("fileID", fileID))
+	renditions := []manifest.Rendition{original}
+
+	// Decide on preprocessing; this may read the whole file once
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		log.Error("failed to seek file", sl.Err(err))
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "internal server error", err)
+	}
+	plan, err := in.Conv.Plan(ctx, fc, dur)
+	if err != nil {
+		log.Error("failed to plan preprocessing", sl.Err(err))
+		return "", echo.NewHTTPError(http.StatusBadRequest, "failed to analyze file, your file is broken: "+err.Error())
+	}
+
+	// Convert file to WAV, unless it already is one and needs no preprocessing
+	asr := original
+	asr.Purpose = manifest.ASR
+	if ext != ".wav" || plan.Applied != nil {
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			log.Error("failed to seek file", sl.Err(err))
+			return "", echo.NewHTTPError(http.StatusInternalServerError, "internal server error", err)
+		}
+
+		wavFC, err := in.Conv.ToWav(ctx, fc, plan)
+		if err != nil {
+			log.Error("failed to start conversion", sl.Err(err))
+			return "", echo.NewHTTPError(http.StatusInternalServerError, "failed to convert file: "+err.Error())
+		}
+		defer wavFC.Close()
+
+		// ffmpeg errors surface here, while the converted stream is being uploaded
+		asr, err = storeRendition(ctx, cli, bucket, manifest.ASR, wavFC.Rename(manifest.RenditionKey(fileID, manifest.ASR, wavFC.Extension())))
+		if err != nil {
+			log.Error("failed to convert and upload wav file", sl.Err(err))
+			return "", echo.NewHTTPError(http.StatusBadRequest, "failed to convert file, your file is broken: "+err.Error())
+		}
+
+		log.Info("Uploaded converted .wav", slog.String("fileID", fileID))
+	}
+	renditions = append(renditions, asr)
+
+	// The ASR audio is normalized, so identical recordings in any container hash the same
+	var duplicateOf string
+	if in.Index != nil && up.Dedup {
+		src, found, err := in.Index.FindProcessed(ctx, asr.Checksum, up.Metadata)
+		if err != nil {
+			log.Warn("failed to look up duplicates", sl.Err(err))
+		} else if found {
+			log.Info("reusing results of an identical recording", slog.String("fileID", fileID), slog.String("source", src))
+			duplicateOf = src
+		}
+	}
+
+	// A missing playback copy is not fatal: the gateway falls back to the original
+	if playback, err := in.storePlayback(ctx, fileID, fc, format, file); err != nil {
+		log.Warn("failed to create playback copy", slog.String("fileID", fileID), sl.Err(err))
+	} else {
+		renditions = append(renditions, playback)
+	}
+
+	if err = storeManifest(ctx, cli, bucket, manifest.Manifest{UUID: fileID, Bucket: bucket, Renditions: renditions}); err != nil {
+		log.Error("failed to upload manifest", sl.Err(err))
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload manifest: "+err.Error())
+	}
+
+	out := uploaded.Record{
+		UUID:   fileID,
+		Bucket: bucket,
+		Key:    asr.Key,
+
+		Preprocessing: plan.Applied,
+		Metadata:      up.Metadata,
+		AudioHash:     asr.Checksum,
+		DuplicateOf:   duplicateOf,
+	}
+	out.Update.UserID = up.UserID
+	out.Update.OGFileName = withoutExt
+	out.Update.OGExtension = ext
+	out.Update.Status = 0
+	out.Update.Renditions = renditions
+
+	if err := in.Writer.Write(ctx, out); err != nil {
+		log.Error("failed to send to kafka", sl.Err(err))
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Internal server error")
+	}
+
+	log.Info("message sent to kafka")
+
+	return fileID, nil
+}
+
+func (in Ingester) storePlayback(ctx context.Context, fileID string, fc entities.File, format formats.Format, file io.Seeker) (manifest.Rendition, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return manifest.Rendition{}, err
+	}
+	pb, err := in.Conv.ToPlayback(ctx, fc, format)
+	if err != nil {
+		return manifest.Rendition{}, err
+	}
+	defer pb.Close()
+	return storeRendition(ctx, in.Client, in.Bucket, manifest.Playback, pb.Rename(manifest.RenditionKey(fileID, manifest.Playback, pb.Extension())))
+}
+
+// detectFormat finds the registered format of a detected mime type. Formats that are not registered
+// themselves are matched by their parent, e.g. an unregistered 3gp file by mp4.
+func detectFormat(reg *formats.Registry, mtype *mimetype.MIME) (formats.Format, bool) {
+	for m := mtype; m != nil; m = m.Parent() {
+		if f, ok := reg.ByMime(m.String()); ok {
+			return f, true
+		}
+	}
+	return formats.Format{}, false
+}
+
+// storeRendition uploads f and describes it for the manifest, computing the checksum
+// and, for streamed files of unknown size, the size on the fly.
+func storeRendition(ctx context.Context, cli Client, bucket string, purpose manifest.Purpose, f entities.File) (manifest.Rendition, error) {
+	h := sha256.New()
+	var n counter
+	tee := entities.New(f.FullName(), "", io.NopCloser(io.TeeReader(f.Data(), io.MultiWriter(h, &n))), f.Size(), f.MimeType())
+	if err := cli.Upload(ctx, bucket, tee); err != nil {
+		return manifest.Rendition{}, err
+	}
+	return manifest.Rendition{
+		Purpose:  purpose,
+		Key:      f.FullName(),
+		Size:     int64(n),
+		Checksum: hex.EncodeToString(h.Sum(nil)),
+		MimeType: f.MimeType(),
+	}, nil
+}
+
+func storeManifest(ctx context.Context, cli Client, bucket string, m manifest.Manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f := entities.New(manifest.Key(m.UUID), "", io.NopCloser(bytes.NewReader(b)), int64(len(b)), "application/json")
+	return cli.Upload(ctx, bucket, f)
+}
+
+// counter counts the bytes written to it.
+type counter int64
+
+func (c *counter) Write(p []byte) (int, error) {
+	*c += counter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kxddry/go-utils/pkg/logger/handlers/sl"
+	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"github.com/kxddry/lectura/uploader/internal/entities"
+	"github.com/kxddry/lectura/uploader/internal/jobs"
+	"github.com/labstack/echo/v4"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+type JobStore interface {
+	NewJob(uid uint, filename string, meta uploaded.Metadata, size int64) jobs.Job
+	Save(ctx context.Context, job jobs.Job) error
+	Get(ctx context.Context, id string, uid uint) (jobs.Job, error)
+	OpenRaw(ctx context.Context, job jobs.Job) (io.ReadSeekCloser, error)
+	DeleteRaw(ctx context.Context, job jobs.Job) error
+}
+
+type JobQueue interface {
+	Submit(job jobs.Job) error
+}
+
+// Jobs hands persisted raw uploads over to the ingest worker pool.
+type Jobs struct {
+	Log    *slog.Logger
+	Client Client
+	Bucket string
+	Store  JobStore
+	Queue  JobQueue
+}
+
+// Enqueue stores data as the raw upload of job, unless data is nil because the raw upload
+// is already in S3, and queues the job. It answers 202 Accepted with the job,
+// or 503 if the ingest queue is full.
+func (j Jobs) Enqueue(ctx context.Context, c echo.Context, job jobs.Job, data io.Reader) error {
+	const op = "handlers.Enqueue"
+	log := j.Log.With(slog.String("op", op), slog.String("job", job.ID))
+
+	if data != nil {
+		raw := entities.New(job.RawKey, "", io.NopCloser(data), job.Size, "application/octet-stream")
+		if err := j.Client.Upload(ctx, j.Bucket, raw); err != nil {
+			log.Error("failed to store raw upload", sl.Err(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to store file")
+		}
+	}
+
+	if err := j.Store.Save(ctx, job); err != nil {
+		log.Error("failed to save job", sl.Err(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to queue file")
+	}
+
+	if err := j.Queue.Submit(job); err != nil {
+		job.Status = jobs.Failed
+		job.Error = err.Error()
+		if err := j.Store.Save(ctx, job); err != nil {
+			log.Error("failed to save job", sl.Err(err))
+		}
+		if err := j.Store.DeleteRaw(ctx, job); err != nil {
+			log.Warn("failed to delete raw upload", sl.Err(err))
+		}
+		log.Warn("ingest queue is full")
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "too many uploads in progress, try again later")
+	}
+
+	log.Info("ingest job queued")
+	return accepted(c, job)
+}
+
+// accepted answers 202 Accepted with job and where to poll it.
+func accepted(c echo.Context, job jobs.Job) error {
+	c.Response().Header().Set(echo.HeaderLocation, "/api/v1/upload/jobs/"+job.ID)
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// JobStatus reports the status of an ingest job, with the failure reason if it failed.
+func JobStatus(ctx context.Context, log *slog.Logger, st JobStore) echo.HandlerFunc {
+	const op = "handlers.JobStatus"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, err := userID(c)
+		if err != nil {
+			return err
+		}
+
+		job, err := st.Get(ctx, c.Param("id"), uid)
+		if err != nil {
+			if errors.Is(err, jobs.ErrNotFound) {
+				return echo.NewHTTPError(http.StatusNotFound, "ingest job not found")
+			}
+			log.Error("failed to get job", sl.Err(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "internal server error")
+		}
+
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		return c.JSON(http.StatusOK, job)
+	}
+}
+
+// Process returns the worker function that ingests a job's raw upload.
+func (in Ingester) Process(st JobStore) jobs.ProcessFunc {
+	return func(ctx context.Context, job jobs.Job) error {
+		file, err := st.OpenRaw(ctx, job)
+		if err != nil {
+			return fmt.Errorf("failed to read upload: %w", err)
+		}
+		defer file.Close()
+
+		_, err = in.Ingest(ctx, Upload{
+			UUID:     job.ID,
+			UserID:   job.UserID,
+			Filename: job.Filename,
+			Metadata: job.Metadata,
+			Dedup:    job.Dedup,
+			File:     file,
+			Size:     job.Size,
+		})
+		return failureReason(err)
+	}
+}
+
+// failureReason turns an Ingest error into the message shown to the user.
+func failureReason(err error) error {
+	var he *echo.HTTPError
+	if !errors.As(err, &he) {
+		return err
+	}
+	return fmt.Errorf("%v", he.Message)
+}
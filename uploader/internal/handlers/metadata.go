@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxTitleLen  = 200
+	maxCourseLen = 100
+	maxTags      = 20
+	maxTagLen    = 32
+	maxPromptLen = 500 // whisper only uses the last 224 tokens of a prompt
+	maxTerms     = 50
+	maxTermLen   = 64
+	dateLayout   = time.DateOnly
+)
+
+var languageRe = regexp.MustCompile(`^[a-z]{2}$`)
+
+// MetadataRequest is the lecture metadata sent along with any kind of upload.
+// Tags and vocabulary terms may be repeated and/or comma-separated.
+type MetadataRequest struct {
+	Title       string   `json:"title" form:"title" query:"title"`
+	Course      string   `json:"course" form:"course" query:"course"`
+	LectureDate string   `json:"lecture_date" form:"lecture_date" query:"lecture_date"`
+	Tags        []string `json:"tags" form:"tags" query:"tags"`
+	Language    string   `json:"language" form:"language" query:"language"`
+	Translate   bool     `json:"translate" form:"translate" query:"translate"`
+	Prompt      string   `json:"prompt" form:"prompt" query:"prompt"`
+	Vocabulary  []string `json:"vocabulary" form:"vocabulary" query:"vocabulary"`
+	// Dedup reuses the results of an identical, already processed recording. Defaults to true.
+	Dedup *bool `json:"dedup" form:"dedup" query:"dedup"`
+}
+
+// DedupEnabled reports whether the upload may reuse the results of an identical recording.
+func (r MetadataRequest) DedupEnabled() bool {
+	return r.Dedup == nil || *r.Dedup
+}
+
+// Validate normalizes the request and checks every field.
+func (r MetadataRequest) Validate() (uploaded.Metadata, error) {
+	m := uploaded.Metadata{
+		Title:       strings.TrimSpace(r.Title),
+		Course:      strings.TrimSpace(r.Course),
+		LectureDate: strings.TrimSpace(r.LectureDate),
+		Language:    strings.ToLower(strings.TrimSpace(r.Language)),
+		Translate:   r.Translate,
+		Prompt:      strings.TrimSpace(r.Prompt),
+	}
+
+	if utf8.RuneCountInString(m.Title) > maxTitleLen {
+		return m, fmt.Errorf("title is longer than %d characters", maxTitleLen)
+	}
+	if utf8.RuneCountInString(m.Course) > maxCourseLen {
+		return m, fmt.Errorf("course is longer than %d characters", maxCourseLen)
+	}
+
+	if m.LectureDate != "" {
+		date, err := time.Parse(dateLayout, m.LectureDate)
+		if err != nil {
+			return m, errors.New("lecture_date must be YYYY-MM-DD")
+		}
+		if date.After(time.Now().AddDate(0, 0, 1)) {
+			return m, errors.New("lecture_date is in the future")
+		}
+	}
+
+	if m.Language != "" && !languageRe.MatchString(m.Language) {
+		return m, errors.New("language must be an ISO 639-1 code, e.g. en")
+	}
+
+	for _, raw := range r.Tags {
+		for _, tag := range strings.Split(raw, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" || slices.Contains(m.Tags, tag) {
+				continue
+			}
+			if utf8.RuneCountInString(tag) > maxTagLen {
+				return m, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLen)
+			}
+			m.Tags = append(m.Tags, tag)
+		}
+	}
+	if len(m.Tags) > maxTags {
+		return m, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+
+	if utf8.RuneCountInString(m.Prompt) > maxPromptLen {
+		return m, fmt.Errorf("prompt is longer than %d characters", maxPromptLen)
+	}
+	for _, raw := range r.Vocabulary {
+		for _, term := range strings.Split(raw, ",") {
+			term = strings.TrimSpace(term)
+			if term == "" || slices.Contains(m.Vocabulary, term) {
+				continue
+			}
+			if utf8.RuneCountInString(term) > maxTermLen {
+				return m, fmt.Errorf("vocabulary term %q is longer than %d characters", term, maxTermLen)
+			}
+			m.Vocabulary = append(m.Vocabulary, term)
+		}
+	}
+	if len(m.Vocabulary) > maxTerms {
+		return m, fmt.Errorf("at most %d vocabulary terms are allowed", maxTerms)
+	}
+
+	return m, nil
+}
+
+// bindMetadata reads the metadata and the dedup switch from the body, or from the query string
+// for requests whose body is the file itself.
+func bindMetadata(c echo.Context, fromQuery bool) (uploaded.Metadata, bool, error) {
+	var req MetadataRequest
+	var err error
+	if fromQuery {
+		err = (&echo.DefaultBinder{}).BindQueryParams(c, &req)
+	} else {
+		err = c.Bind(&req)
+	}
+	if err != nil {
+		return uploaded.Metadata{}, false, echo.NewHTTPError(http.StatusBadRequest, "invalid metadata")
+	}
+
+	m, err := req.Validate()
+	if err != nil {
+		return uploaded.Metadata{}, false, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return m, req.DedupEnabled(), nil
+}
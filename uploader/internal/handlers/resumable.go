@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/kxddry/go-utils/pkg/logger/handlers/sl"
+	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"github.com/kxddry/lectura/uploader/internal/sessions"
+	"github.com/labstack/echo/v4"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Resumable upload protocol (tus-style):
+//
+//	POST   /api/v1/upload/sessions              Upload-Length + ?filename= and metadata -> 201, Location, Upload-Offset: 0
+//	PATCH  /api/v1/upload/sessions/:id          Upload-Offset, body is the chunk -> 204, Upload-Offset
+//	HEAD   /api/v1/upload/sessions/:id          -> 200, Upload-Offset, Upload-Length
+//	POST   /api/v1/upload/sessions/:id/finalize -> 202 with the ingest job, same as POST /api/v1/upload;
+//	                                               finalizing again returns the same job
+//	DELETE /api/v1/upload/sessions/:id          -> 204
+const (
+	HeaderUploadLength  = "Upload-Length"
+	HeaderUploadOffset  = "Upload-Offset"
+	HeaderUploadExpires = "Upload-Expires"
+	offsetContentType   = "application/offset+octet-stream"
+)
+
+type SessionStore interface {
+	Create(ctx context.Context, uid uint, filename string, meta uploaded.Metadata, dedup bool, length int64) (sessions.Session, error)
+	Get(ctx context.Context, id string, uid uint) (sessions.Session, error)
+	Append(ctx context.Context, id string, uid uint, offset int64, data io.Reader, size int64) (sessions.Session, error)
+	Finalize(ctx context.Context, id string, uid uint, jobID string, enqueue func(data io.Reader) error) (sessions.Session, error)
+	Delete(ctx context.Context, id string) error
+}
+
+func CreateSession(ctx context.Context, log *slog.Logger, st SessionStore, maxSize int64) echo.HandlerFunc {
+	const op = "handlers.CreateSession"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, err := userID(c)
+		if err != nil {
+			return err
+		}
+
+		length, err := strconv.ParseInt(c.Request().Header.Get(HeaderUploadLength), 10, 64)
+		if err != nil || length <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid "+HeaderUploadLength)
+		}
+		if length > maxSize {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "file too large")
+		}
+
+		filename := c.QueryParam("filename")
+		if filename == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "filename is required")
+		}
+
+		meta, dedup, err := bindMetadata(c, true)
+		if err != nil {
+			return err
+		}
+
+		sess, err := st.Create(ctx, uid, filename, meta, dedup, length)
+		if err != nil {
+			log.Error("failed to create upload session", sl.Err(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create upload session")
+		}
+
+		log.Info("upload session created", slog.String("session", sess.ID))
+		setSessionHeaders(c, sess)
+		c.Response().Header().Set(echo.HeaderLocation, c.Request().URL.Path+"/"+sess.ID)
+		return c.JSON(http.StatusCreated, sess)
+	}
+}
+
+func SessionOffset(ctx context.Context, log *slog.Logger, st SessionStore) echo.HandlerFunc {
+	const op = "handlers.SessionOffset"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, err := userID(c)
+		if err != nil {
+			return err
+		}
+
+		sess, err := st.Get(ctx, c.Param("id"), uid)
+		if err != nil {
+			return sessionError(log, err)
+		}
+
+		setSessionHeaders(c, sess)
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+func PatchSession(ctx context.Context, log *slog.Logger, st SessionStore, maxChunk int64) echo.HandlerFunc {
+	const op = "handlers.PatchSession"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, err := userID(c)
+		if err != nil {
+			return err
+		}
+
+		req := c.Request()
+		if req.Header.Get(echo.HeaderContentType) != offsetContentType {
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, "content type must be "+offsetContentType)
+		}
+
+		offset, err := strconv.ParseInt(req.Header.Get(HeaderUploadOffset), 10, 64)
+		if err != nil || offset < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid "+HeaderUploadOffset)
+		}
+
+		size := req.ContentLength
+		if size <= 0 {
+			return echo.NewHTTPError(http.StatusLengthRequired, "Content-Length is required")
+		}
+		if size > maxChunk {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "chunk too large")
+		}
+
+		sess, err := st.Append(ctx, c.Param("id"), uid, offset, req.Body, size)
+		if err != nil {
+			setSessionHeaders(c, sess)
+			return sessionError(log, err)
+		}
+
+		setSessionHeaders(c, sess)
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func FinalizeSession(ctx context.Context, log *slog.Logger, j Jobs, st SessionStore) echo.HandlerFunc {
+	const op = "handlers.FinalizeSession"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, err := userID(c)
+		if err != nil {
+			return err
+		}
+
+		sess, err := st.Get(ctx, c.Param("id"), uid)
+		if err != nil {
+			return sessionError(log, err)
+		}
+
+		job := j.Store.NewJob(uid, sess.Filename, sess.Metadata, sess.Length)
+		job.Dedup = sess.Dedup
+		var enqueued error
+		sess, err = st.Finalize(ctx, sess.ID, uid, job.ID, func(data io.Reader) error {
+			enqueued = j.Enqueue(ctx, c, job, data)
+			return enqueued
+		})
+		switch {
+		case enqueued != nil:
+			return enqueued
+		case errors.Is(err, sessions.ErrFinalized):
+			// a repeated finalize gets the job of the first one
+			job, err = j.Store.Get(ctx, sess.JobID, uid)
+			if err != nil {
+				log.Error("failed to get job of finalized upload session", slog.String("session", sess.ID), sl.Err(err))
+				return echo.NewHTTPError(http.StatusInternalServerError, "internal server error")
+			}
+			return accepted(c, job)
+		case err != nil:
+			return sessionError(log, err)
+		}
+		return nil
+	}
+}
+
+func DeleteSession(ctx context.Context, log *slog.Logger, st SessionStore) echo.HandlerFunc {
+	const op = "handlers.DeleteSession"
+	log = log.With(slog.String("op", op))
+
+	return func(c echo.Context) error {
+		uid, err := userID(c)
+		if err != nil {
+			return err
+		}
+
+		sess, err := st.Get(ctx, c.Param("id"), uid)
+		if err != nil {
+			return sessionError(log, err)
+		}
+
+		if err = st.Delete(ctx, sess.ID); err != nil {
+			log.Error("failed to delete upload session", sl.Err(err))
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete upload session")
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func userID(c echo.Context) (uint, error) {
+	uid, ok := c.Get("uid").(uint)
+	if !ok || uid == 0 {
+		return 0, echo.NewHTTPError(http.StatusUnauthorized, "uid missing")
+	}
+	return uid, nil
+}
+
+func setSessionHeaders(c echo.Context, sess sessions.Session) {
+	if sess.ID == "" {
+		return
+	}
+	h := c.Response().Header()
+	h.Set(HeaderUploadOffset, strconv.FormatInt(sess.Offset, 10))
+	h.Set(HeaderUploadLength, strconv.FormatInt(sess.Length, 10))
+	h.Set(HeaderUploadExpires, sess.ExpiresAt.Format(time.RFC1123))
+}
+
+func sessionError(log *slog.Logger, err error) error {
+	switch {
+	case errors.Is(err, sessions.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "upload session not found")
+	case errors.Is(err, sessions.ErrExpired):
+		return echo.NewHTTPError(http.StatusGone, "upload session expired")
+	case errors.Is(err, sessions.ErrOffsetMismatch):
+		return echo.NewHTTPError(http.StatusConflict, "upload offset mismatch")
+	case errors.Is(err, sessions.ErrTooLarge):
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "chunk exceeds upload length")
+	case errors.Is(err, sessions.ErrIncomplete):
+		return echo.NewHTTPError(http.StatusConflict, "upload is not complete")
+	default:
+		log.Error("upload session error", sl.Err(err))
+		return echo.NewHTTPError(http.StatusInternalServerError, "internal server error")
+	}
+}
@@ -0,0 +1,249 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"github.com/kxddry/lectura/uploader/internal/entities"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("ingest job not found")
+
+type Status string
+
+const (
+	Queued     Status = "queued"
+	Converting Status = "converting"
+	Failed     Status = "failed"
+	Published  Status = "published"
+)
+
+// Done reports whether the job reached a final status.
+func (s Status) Done() bool {
+	return s == Failed || s == Published
+}
+
+// Job is an upload waiting for, or gone through, probing, conversion and publishing.
+// The job ID is also the UUID of the published file.
+type Job struct {
+	ID        string            `json:"id"`
+	UserID    uint              `json:"user_id"`
+	Filename  string            `json:"filename"`
+	Metadata  uploaded.Metadata `json:"metadata"`
+	Dedup     bool              `json:"dedup"` // reuse the results of an identical recording
+	RawKey    string            `json:"-"`     // S3 key of the raw upload, removed once the job is done
+	Size      int64             `json:"size"`
+	Status    Status            `json:"status"`
+	Error     string            `json:"error,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+type Client interface {
+	Upload(ctx context.Context, bucket string, file uploaded.File) error
+	Download(ctx context.Context, bucket string, key string) (io.ReadCloser, error)
+	Open(ctx context.Context, bucket string, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, bucket string, key string) error
+	List(ctx context.Context, bucket, prefix string) ([]string, error)
+}
+
+// Store keeps jobs and their raw uploads in S3 under <prefix>/<id>/, so queued jobs
+// survive uploader restarts.
+type Store struct {
+	cli    Client
+	bucket string
+	prefix string
+}
+
+func NewStore(cli Client, bucket, prefix string) *Store {
+	return &Store{
+		cli:    cli,
+		bucket: bucket,
+		prefix: strings.TrimSuffix(prefix, "/"),
+	}
+}
+
+// NewJob returns a queued job whose raw upload should be stored under job.RawKey.
+func (s *Store) NewJob(uid uint, filename string, meta uploaded.Metadata, size int64) Job {
+	id := uuid.New().String()
+	now := time.Now().UTC()
+	return Job{
+		ID:        id,
+		UserID:    uid,
+		Filename:  filename,
+		Metadata:  meta,
+		RawKey:    s.prefix + "/" + id + "/raw",
+		Size:      size,
+		Status:    Queued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Save creates or overwrites the job.
+func (s *Store) Save(ctx context.Context, job Job) error {
+	const op = "jobs.Save"
+	job.UpdatedAt = time.Now().UTC()
+	b, err := json.Marshal(stored{Job: job, RawKey: job.RawKey})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	f := entities.New(s.infoKey(job.ID), "", io.NopCloser(bytes.NewReader(b)), int64(len(b)), "application/json")
+	if err = s.cli.Upload(ctx, s.bucket, f); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// Get returns the job if it exists and belongs to uid.
+func (s *Store) Get(ctx context.Context, id string, uid uint) (Job, error) {
+	const op = "jobs.Get"
+	job, err := s.load(ctx, id)
+	if err != nil {
+		return Job{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if job.UserID != uid {
+		return Job{}, fmt.Errorf("%s: %w", op, ErrNotFound)
+	}
+	return job, nil
+}
+
+// OpenRaw opens the raw upload of the job where it is stored; seeking reads only the requested range.
+func (s *Store) OpenRaw(ctx context.Context, job Job) (io.ReadSeekCloser, error) {
+	return s.cli.Open(ctx, s.bucket, job.RawKey)
+}
+
+// DeleteRaw removes the raw upload of the job. The job itself is kept for status requests.
+func (s *Store) DeleteRaw(ctx context.Context, job Job) error {
+	return s.cli.Delete(ctx, s.bucket, job.RawKey)
+}
+
+// Pending returns every job that has not finished, oldest first.
+func (s *Store) Pending(ctx context.Context) ([]Job, error) {
+	const op = "jobs.Pending"
+	var pending []Job
+	err := s.each(ctx, func(job Job) error {
+		if !job.Status.Done() {
+			pending = append(pending, job)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	// job keys are random, so sort by age
+	slices.SortFunc(pending, func(a, b Job) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return pending, nil
+}
+
+// Cleanup deletes finished jobs older than ttl and returns the number of removed jobs.
+func (s *Store) Cleanup(ctx context.Context, ttl time.Duration) (int, error) {
+	const op = "jobs.Cleanup"
+	var removed int
+	deadline := time.Now().Add(-ttl)
+	err := s.each(ctx, func(job Job) error {
+		if !job.Status.Done() || job.UpdatedAt.After(deadline) {
+			return nil
+		}
+		if err := s.delete(ctx, job.ID); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("%s: %w", op, err)
+	}
+	return removed, nil
+}
+
+// RunCleanup calls Cleanup every interval until ctx is done.
+func (s *Store) RunCleanup(ctx context.Context, interval, ttl time.Duration, onErr func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Cleanup(ctx, ttl); err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}
+}
+
+const infoName = "info.json"
+
+// stored keeps RawKey, which is hidden from API responses.
+type stored struct {
+	Job
+	RawKey string `json:"raw_key"`
+}
+
+func (s *Store) infoKey(id string) string {
+	return s.prefix + "/" + id + "/" + infoName
+}
+
+func (s *Store) each(ctx context.Context, fn func(Job) error) error {
+	keys, err := s.cli.List(ctx, s.bucket, s.prefix+"/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/"+infoName) {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(key, s.prefix+"/"), "/"+infoName)
+		job, err := s.load(ctx, id)
+		if err != nil {
+			continue
+		}
+		if err = fn(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) delete(ctx context.Context, id string) error {
+	keys, err := s.cli.List(ctx, s.bucket, s.prefix+"/"+id+"/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = s.cli.Delete(ctx, s.bucket, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) load(ctx context.Context, id string) (Job, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return Job{}, ErrNotFound
+	}
+	rc, err := s.cli.Download(ctx, s.bucket, s.infoKey(id))
+	if err != nil {
+		return Job{}, err
+	}
+	defer rc.Close()
+
+	var st stored
+	if err = json.NewDecoder(rc).Decode(&st); err != nil {
+		// minio returns the NoSuchKey error lazily, on the first read
+		return Job{}, ErrNotFound
+	}
+	st.Job.RawKey = st.RawKey
+	return st.Job, nil
+}
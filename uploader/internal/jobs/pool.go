@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"github.com/kxddry/go-utils/pkg/logger/handlers/sl"
+	"log/slog"
+	"sync"
+)
+
+var ErrQueueFull = errors.New("ingest queue is full")
+
+// ProcessFunc runs a single job. The returned error is stored as the failure reason.
+type ProcessFunc func(ctx context.Context, job Job) error
+
+// Pool runs jobs on a fixed number of workers. Jobs that do not fit into the queue are rejected.
+type Pool struct {
+	log     *slog.Logger
+	store   *Store
+	process ProcessFunc
+	queue   chan Job
+	wg      sync.WaitGroup
+}
+
+func NewPool(log *slog.Logger, store *Store, process ProcessFunc, queueSize int) *Pool {
+	return &Pool{
+		log:     log,
+		store:   store,
+		process: process,
+		queue:   make(chan Job, queueSize),
+	}
+}
+
+// Submit queues a saved job without blocking.
+func (p *Pool) Submit(job Job) error {
+	select {
+	case p.queue <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Start runs workers until ctx is done. Jobs left unfinished by a previous run are queued first.
+func (p *Pool) Start(ctx context.Context, workers int) {
+	const op = "jobs.Pool.Start"
+	log := p.log.With(slog.String("op", op))
+
+	for range workers {
+		p.wg.Add(1)
+		go p.work(ctx)
+	}
+
+	pending, err := p.store.Pending(ctx)
+	if err != nil {
+		log.Error("failed to list pending jobs", sl.Err(err))
+		return
+	}
+	if len(pending) > 0 {
+		log.Info("resuming pending jobs", slog.Int("count", len(pending)))
+	}
+	go func() {
+		for _, job := range pending {
+			select {
+			case <-ctx.Done():
+				return
+			case p.queue <- job:
+			}
+		}
+	}()
+}
+
+// Wait blocks until every worker has returned.
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context) {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-p.queue:
+			p.run(ctx, job)
+		}
+	}
+}
+
+func (p *Pool) run(ctx context.Context, job Job) {
+	log := p.log.With(slog.String("op", "jobs.Pool.run"), slog.String("job", job.ID))
+
+	job.Status = Converting
+	job.Error = ""
+	if err := p.store.Save(ctx, job); err != nil {
+		log.Error("failed to update job", sl.Err(err))
+	}
+
+	err := p.process(ctx, job)
+	if ctx.Err() != nil {
+		// shutting down: keep the raw upload, the job is resumed on the next start
+		return
+	}
+	if err != nil {
+		log.Info("ingest job failed", sl.Err(err))
+		job.Status = Failed
+		job.Error = err.Error()
+	} else {
+		job.Status = Published
+	}
+
+	if err = p.store.Save(ctx, job); err != nil {
+		log.Error("failed to update job", sl.Err(err))
+	}
+	if err = p.store.DeleteRaw(ctx, job); err != nil {
+		log.Warn("failed to delete raw upload", sl.Err(err))
+	}
+}
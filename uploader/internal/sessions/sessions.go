@@ -0,0 +1,357 @@
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"github.com/kxddry/lectura/uploader/internal/entities"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound       = errors.New("upload session not found")
+	ErrExpired        = errors.New("upload session expired")
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrTooLarge       = errors.New("chunk exceeds declared upload length")
+	ErrIncomplete     = errors.New("upload is not complete")
+	ErrFinalized      = errors.New("upload session already finalized")
+)
+
+// Session describes a resumable upload. The session itself and every received chunk
+// are kept in S3 under <prefix>/<id>/, so sessions survive uploader restarts.
+type Session struct {
+	ID        string            `json:"id"`
+	UserID    uint              `json:"user_id"`
+	Filename  string            `json:"filename"`
+	Metadata  uploaded.Metadata `json:"metadata"`
+	Dedup     bool              `json:"dedup"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	JobID     string            `json:"job_id,omitempty"` // set once the session is finalized
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Complete reports whether every declared byte has been received.
+func (s Session) Complete() bool {
+	return s.Offset == s.Length
+}
+
+type Client interface {
+	Upload(ctx context.Context, bucket string, file uploaded.File) error
+	Download(ctx context.Context, bucket string, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, bucket string, key string) error
+	List(ctx context.Context, bucket, prefix string) ([]string, error)
+}
+
+type Store struct {
+	cli    Client
+	bucket string
+	prefix string
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func New(cli Client, bucket, prefix string, ttl time.Duration) *Store {
+	return &Store{
+		cli:    cli,
+		bucket: bucket,
+		prefix: strings.TrimSuffix(prefix, "/"),
+		ttl:    ttl,
+		locks:  make(map[string]*sync.Mutex),
+	}
+}
+
+// Create registers a new session for a file of the given length.
+func (s *Store) Create(ctx context.Context, uid uint, filename string, meta uploaded.Metadata, dedup bool, length int64) (Session, error) {
+	const op = "sessions.Create"
+	now := time.Now().UTC()
+	sess := Session{
+		ID:        uuid.New().String(),
+		UserID:    uid,
+		Filename:  filename,
+		Metadata:  meta,
+		Dedup:     dedup,
+		Length:    length,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+	if err := s.save(ctx, sess); err != nil {
+		return Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return sess, nil
+}
+
+// Get returns the session if it exists, belongs to uid and has not expired.
+func (s *Store) Get(ctx context.Context, id string, uid uint) (Session, error) {
+	const op = "sessions.Get"
+	sess, err := s.load(ctx, id)
+	if err != nil {
+		return Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if sess.UserID != uid {
+		return Session{}, fmt.Errorf("%s: %w", op, ErrNotFound)
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		return Session{}, fmt.Errorf("%s: %w", op, ErrExpired)
+	}
+	return sess, nil
+}
+
+// Append stores a chunk starting at offset. The offset must equal the current session offset.
+// Every accepted chunk extends the session expiry.
+func (s *Store) Append(ctx context.Context, id string, uid uint, offset int64, data io.Reader, size int64) (Session, error) {
+	const op = "sessions.Append"
+	unlock := s.lock(id)
+	defer unlock()
+
+	sess, err := s.Get(ctx, id, uid)
+	if err != nil {
+		return Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if offset != sess.Offset {
+		return sess, fmt.Errorf("%s: %w", op, ErrOffsetMismatch)
+	}
+	if sess.Offset+size > sess.Length {
+		return sess, fmt.Errorf("%s: %w", op, ErrTooLarge)
+	}
+
+	chunk := entities.New(s.chunkKey(id, offset), "", io.NopCloser(data), size, "application/octet-stream")
+	if err = s.cli.Upload(ctx, s.bucket, chunk); err != nil {
+		return sess, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sess.Offset += size
+	sess.ExpiresAt = time.Now().UTC().Add(s.ttl)
+	if err = s.save(ctx, sess); err != nil {
+		return sess, fmt.Errorf("%s: %w", op, err)
+	}
+	return sess, nil
+}
+
+// Finalize hands a complete session over to enqueue as the ingest job jobID, once. The job ID is stored
+// in the session before enqueue runs, and the session is locked until it returns, so a repeated call
+// gets the session with the job ID of the first one and ErrFinalized. If enqueue fails, the session
+// can be finalized again. The chunks are streamed to enqueue straight from S3.
+func (s *Store) Finalize(ctx context.Context, id string, uid uint, jobID string, enqueue func(data io.Reader) error) (Session, error) {
+	const op = "sessions.Finalize"
+	unlock := s.lock(id)
+	defer unlock()
+
+	sess, err := s.Get(ctx, id, uid)
+	if err != nil {
+		return Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if sess.JobID != "" {
+		return sess, fmt.Errorf("%s: %w", op, ErrFinalized)
+	}
+	if !sess.Complete() {
+		return sess, fmt.Errorf("%s: %w", op, ErrIncomplete)
+	}
+
+	keys, err := s.cli.List(ctx, s.bucket, s.chunkPrefix(sess.ID))
+	if err != nil {
+		return sess, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// the session outlives its chunks until it expires, so repeated calls still find the job
+	sess.JobID = jobID
+	sess.ExpiresAt = time.Now().UTC().Add(s.ttl)
+	if err = s.save(ctx, sess); err != nil {
+		return sess, fmt.Errorf("%s: %w", op, err)
+	}
+
+	data := &chunks{ctx: ctx, cli: s.cli, bucket: s.bucket, keys: keys, length: sess.Length}
+	err = enqueue(data)
+	_ = data.Close()
+	if err != nil {
+		sess.JobID = ""
+		if serr := s.save(ctx, sess); serr != nil {
+			err = errors.Join(err, serr)
+		}
+		return sess, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// leftovers are removed with the session by the cleanup loop
+	for _, key := range keys {
+		_ = s.cli.Delete(ctx, s.bucket, key)
+	}
+	return sess, nil
+}
+
+// chunks reads the chunks of a session in order, downloading each one only when the previous one is used up.
+// Chunk keys are zero-padded offsets, so lexical order is byte order.
+type chunks struct {
+	ctx    context.Context
+	cli    Client
+	bucket string
+	keys   []string
+	cur    io.ReadCloser
+	read   int64
+	length int64
+}
+
+func (c *chunks) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if len(c.keys) == 0 {
+				if c.read != c.length {
+					return 0, fmt.Errorf("assembled %d bytes, expected %d", c.read, c.length)
+				}
+				return 0, io.EOF
+			}
+			rc, err := c.cli.Download(c.ctx, c.bucket, c.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			c.cur, c.keys = rc, c.keys[1:]
+		}
+
+		n, err := c.cur.Read(p)
+		c.read += int64(n)
+		if errors.Is(err, io.EOF) {
+			_ = c.cur.Close()
+			c.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *chunks) Close() error {
+	if c.cur == nil {
+		return nil
+	}
+	err := c.cur.Close()
+	c.cur = nil
+	return err
+}
+
+// Delete removes the session and all of its chunks.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	const op = "sessions.Delete"
+	keys, err := s.cli.List(ctx, s.bucket, s.prefix+"/"+id+"/")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	for _, key := range keys {
+		if err = s.cli.Delete(ctx, s.bucket, key); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.locks, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// Cleanup deletes every expired session together with its partial chunks
+// and returns the number of removed sessions.
+func (s *Store) Cleanup(ctx context.Context) (int, error) {
+	const op = "sessions.Cleanup"
+	keys, err := s.cli.List(ctx, s.bucket, s.prefix+"/")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var removed int
+	now := time.Now()
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/"+infoName) {
+			continue
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(key, s.prefix+"/"), "/"+infoName)
+		sess, err := s.load(ctx, id)
+		if err != nil || now.Before(sess.ExpiresAt) {
+			continue
+		}
+		if err = s.Delete(ctx, id); err != nil {
+			return removed, fmt.Errorf("%s: %w", op, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// RunCleanup calls Cleanup every interval until ctx is done.
+func (s *Store) RunCleanup(ctx context.Context, interval time.Duration, onErr func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Cleanup(ctx); err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}
+}
+
+const infoName = "info.json"
+
+func (s *Store) infoKey(id string) string {
+	return s.prefix + "/" + id + "/" + infoName
+}
+
+func (s *Store) chunkPrefix(id string) string {
+	return s.prefix + "/" + id + "/chunks/"
+}
+
+func (s *Store) chunkKey(id string, offset int64) string {
+	return fmt.Sprintf("%s%020d", s.chunkPrefix(id), offset)
+}
+
+func (s *Store) save(ctx context.Context, sess Session) error {
+	b, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	f := entities.New(s.infoKey(sess.ID), "", io.NopCloser(bytes.NewReader(b)), int64(len(b)), "application/json")
+	return s.cli.Upload(ctx, s.bucket, f)
+}
+
+func (s *Store) load(ctx context.Context, id string) (Session, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return Session{}, ErrNotFound
+	}
+	rc, err := s.cli.Download(ctx, s.bucket, s.infoKey(id))
+	if err != nil {
+		return Session{}, err
+	}
+	defer rc.Close()
+
+	var sess Session
+	if err = json.NewDecoder(rc).Decode(&sess); err != nil {
+		// minio returns the NoSuchKey error lazily, on the first read
+		return Session{}, ErrNotFound
+	}
+	return sess, nil
+}
+
+func (s *Store) lock(id string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[id] = l
+	}
+	s.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
@@ -1,85 +1,212 @@
 package converter
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/kxddry/lectura/shared/utils/formats"
 	"github.com/kxddry/lectura/uploader/internal/entities"
 	"io"
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-// ConvertToWav converts a file to wav and returns a FileConfig.
-// Always defer closing the fc.File reader after calling the function
-func ConvertToWav(file entities.File) (entities.File, error) {
+// Options limit every ffmpeg process started by a Converter.
+type Options struct {
+	Timeout    time.Duration // kills ffmpeg after this long; 0 means no limit
+	Threads    int           // ffmpeg -threads; 0 lets ffmpeg decide
+	MaxMemory  int64         // address space limit in bytes, enforced with prlimit; 0 means no limit
+	Preprocess Preprocess    // filters applied to the ASR audio
+}
+
+// Converter streams files through ffmpeg: input goes to ffmpeg's stdin (or is read in place
+// if it is already on disk, or by URL if it is in S3) and the output is read from ffmpeg's stdout, so nothing is copied to /tmp.
+type Converter struct {
+	opts Options
+}
+
+func New(opts Options) Converter {
+	return Converter{opts: opts}
+}
+
+// ToWav converts a file to 16 kHz mono wav, applying the preprocessing filters of plan.
+// The returned file has an unknown size (-1) and must always be closed.
+func (c Converter) ToWav(ctx context.Context, file entities.File, plan Plan) (entities.File, error) {
+	args := []string{
+		"-vn",
+		"-ar", "16000", // sampling rate
+		"-ac", "1", // mono
+	}
+	if len(plan.Filters) > 0 {
+		args = append(args, "-af", strings.Join(plan.Filters, ","))
+	}
+	return c.convert(ctx, file, ".wav", "audio/wav", append(args, "-f", "wav")...)
+}
+
+// ToPlayback converts a file to a compressed copy suitable for streaming in the browser:
+// H.264/AAC MP4 capped at 720p for video formats, AAC M4A for audio formats. The output is fragmented,
+// because ffmpeg cannot seek back in a pipe to write the index at the front.
+// The returned file has an unknown size (-1) and must always be closed.
+func (c Converter) ToPlayback(ctx context.Context, file entities.File, format formats.Format) (entities.File, error) {
+	if format.Video {
+		return c.convert(ctx, file, ".mp4", "video/mp4",
+			"-vf", "scale=-2:'min(720,ih)'",
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "28",
+			"-c:a", "aac", "-b:a", "96k",
+			"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+			"-f", "mp4",
+		)
+	}
+	return c.convert(ctx, file, ".m4a", "audio/mp4",
+		"-vn",
+		"-c:a", "aac", "-b:a", "64k",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "ipod",
+	)
+}
+
+// convert starts ffmpeg with args and returns its stdout as a file with the given extension and mime type.
+func (c Converter) convert(ctx context.Context, file entities.File, outExt, mimeType string, args ...string) (entities.File, error) {
 	empty := entities.File{}
 
 	full := file.FullName()
-	ext := path.Ext(full)
+	withoutExt := path.Base(full[:len(full)-len(path.Ext(full))])
 
-	withoutExt := full[:len(full)-len(ext)]
+	s, err := c.start(ctx, file.Data(), args...)
+	if err != nil {
+		return empty, err
+	}
 
-	// Prepare paths
-	tmpInputPath := "/tmp/" + file.FullName()
-	tmpOutputPath := "/tmp/" + withoutExt + ".wav"
+	return entities.New(withoutExt, outExt, s, -1, mimeType), nil
+}
+
+func (c Converter) start(ctx context.Context, in io.Reader, args ...string) (*Stream, error) {
+	var cancel context.CancelFunc
+	if c.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
 
-	// Make sure we clean up both temp files
-	defer func() {
-		_ = os.Remove(tmpInputPath)
-		_ = os.Remove(tmpOutputPath)
-	}()
+	cmd := c.command(ctx, in, append(args, "pipe:1")...)
+	stderr := &tail{max: stderrTail}
+	cmd.Stderr = stderr
 
-	// Write incoming file to a temp
-	inFile, err := os.Create(tmpInputPath)
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return empty,
-			fmt.Errorf("failed to create temp input file: %w", err)
+		cancel()
+		return nil, fmt.Errorf("ffmpeg stdout: %w", err)
 	}
-	// Caller must close the original fc.File; we only close our temp writer
-	defer inFile.Close()
+	if err = cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("ffmpeg start: %w", err)
+	}
+
+	return &Stream{cmd: cmd, stdout: stdout, stderr: stderr, ctx: ctx, cancel: cancel}, nil
+}
 
-	if _, err := io.Copy(inFile, file.Data()); err != nil {
-		return empty,
-			fmt.Errorf("failed to write to temp input file: %w", err)
+// command builds an ffmpeg command reading in, with the configured thread and memory limits.
+func (c Converter) command(ctx context.Context, in io.Reader, args ...string) *exec.Cmd {
+	cmdArgs := []string{"-hide_banner", "-y"}
+	if c.opts.Threads > 0 {
+		cmdArgs = append(cmdArgs, "-threads", strconv.Itoa(c.opts.Threads))
 	}
 
-	// Convert with ffmpeg
-	if err := convertFileToWav(tmpInputPath, tmpOutputPath); err != nil {
-		return empty, err
+	// files on disk are read in place and S3 objects by URL, so ffmpeg can seek (e.g. MP4 with the index at the end)
+	switch f := in.(type) {
+	case *os.File:
+		cmdArgs = append(cmdArgs, "-nostdin", "-i", f.Name())
+		in = nil
+	case interface{ URL() string }:
+		cmdArgs = append(cmdArgs, "-nostdin", "-i", f.URL())
+		in = nil
+	default:
+		cmdArgs = append(cmdArgs, "-i", "pipe:0")
 	}
+	cmdArgs = append(cmdArgs, args...)
 
-	// Open the converted wav
-	outFile, err := os.Open(tmpOutputPath)
-	if err != nil {
-		return empty,
-			fmt.Errorf("failed to open converted wav: %w", err)
+	name := "ffmpeg"
+	if c.opts.MaxMemory > 0 {
+		cmdArgs = append([]string{"--as=" + strconv.FormatInt(c.opts.MaxMemory, 10), "--", name}, cmdArgs...)
+		name = "prlimit"
 	}
 
-	info, err := outFile.Stat()
-	if err != nil {
-		_ = outFile.Close()
-		return empty,
-			fmt.Errorf("failed to stat converted wav: %w", err)
+	cmd := exec.CommandContext(ctx, name, cmdArgs...)
+	cmd.Stdin = in
+	return cmd
+}
+
+// Stream is the output of a running ffmpeg process.
+// Reading it to the end waits for ffmpeg; if ffmpeg failed, the final Read returns
+// the error instead of io.EOF, so a consumer never mistakes a broken output for a complete one.
+type Stream struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr *tail
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	once sync.Once
+	err  error
+}
+
+func (s *Stream) Read(p []byte) (int, error) {
+	n, err := s.stdout.Read(p)
+	if errors.Is(err, io.EOF) {
+		if werr := s.wait(); werr != nil {
+			return n, werr
+		}
 	}
+	return n, err
+}
 
-	out := entities.New(withoutExt, ".wav", outFile, info.Size(), "audio/wav")
+// Close stops ffmpeg if it is still running. Conversion errors are reported by Read.
+func (s *Stream) Close() error {
+	s.cancel()
+	_ = s.wait()
+	return nil
+}
 
-	return out, nil
+func (s *Stream) wait() error {
+	s.once.Do(func() {
+		if err := s.cmd.Wait(); err != nil {
+			if errors.Is(s.ctx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("%w: %w", err, context.DeadlineExceeded)
+			}
+			s.err = fmt.Errorf("ffmpeg error: %w: %s", err, s.stderr.String())
+		}
+		s.cancel()
+	})
+	return s.err
 }
 
-func convertFileToWav(inputPath, outPath string) error {
-	cmd := exec.Command("ffmpeg",
-		"-i", inputPath,
-		"-ar", "16000", // sampling rate
-		"-ac", "1", // mono
-		"-f", "wav", outPath,
-	)
+// stderrTail is how much of ffmpeg's stderr is kept for error messages.
+const stderrTail = 4096
 
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg error: %v", err)
+// tail keeps the last max bytes written to it.
+type tail struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = t.buf[len(t.buf)-t.max:]
 	}
-	return nil
+	return len(p), nil
+}
+
+func (t *tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
 }
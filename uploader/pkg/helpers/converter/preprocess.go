@@ -0,0 +1,213 @@
+package converter
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"github.com/kxddry/lectura/uploader/internal/entities"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Preprocess toggles the filters applied to the audio before it goes to ASR.
+type Preprocess struct {
+	Loudnorm         bool          // EBU R128 loudness normalization
+	TargetLUFS       float64       // integrated loudness target, e.g. -16
+	TrimSilence      bool          // remove leading and trailing silence
+	NoiseReduction   bool          // FFT denoiser
+	RemovePauses     bool          // shorten pauses in speech longer than MaxPause, found by voice activity detection
+	MaxPause         time.Duration // pauses longer than this are shortened to KeepPause
+	KeepPause        time.Duration // how much of a removed pause is kept
+	SilenceThreshold float64       // dBFS below which audio counts as silence, e.g. -35
+	MinSilence       time.Duration // shortest silence that is detected at all
+}
+
+// Steps recorded in uploaded.Preprocessing.
+const (
+	StepTrimSilence  = "trim_silence"
+	StepRemovePauses = "remove_pauses"
+	StepDenoise      = "denoise"
+	StepLoudnorm     = "loudnorm"
+)
+
+// Plan is the filter chain for a single file, together with its description for uploaded.Record.
+type Plan struct {
+	Filters []string
+	Applied *uploaded.Preprocessing // nil when no filter is applied
+}
+
+// Plan decides which filters to apply to file. Silence trimming and pause removal need to know where
+// the silence is, so if either is enabled, Plan reads the whole file once: ffmpeg's silencedetect finds
+// the leading and trailing silence by level, and the voice activity detector finds the pauses in speech.
+// duration is the length of the file in seconds. The file must be rewound before it is converted.
+func (c Converter) Plan(ctx context.Context, file entities.File, duration float64) (Plan, error) {
+	p := c.opts.Preprocess
+	var plan Plan
+	applied := &uploaded.Preprocessing{}
+
+	if p.TrimSilence || p.RemovePauses {
+		silences, pauses, err := c.detectSilence(ctx, file, duration)
+		if err != nil {
+			return Plan{}, err
+		}
+		cuts := p.cuts(silences, pauses, duration)
+		if len(cuts) > 0 {
+			plan.Filters = append(plan.Filters, selectFilter(cuts), "asetpts=N/SR/TB")
+			applied.Cuts = cuts
+		}
+		if p.TrimSilence {
+			applied.Steps = append(applied.Steps, StepTrimSilence)
+		}
+		if p.RemovePauses {
+			applied.Steps = append(applied.Steps, StepRemovePauses)
+		}
+	}
+
+	if p.NoiseReduction {
+		plan.Filters = append(plan.Filters, "afftdn=nf=-25")
+		applied.Steps = append(applied.Steps, StepDenoise)
+	}
+
+	if p.Loudnorm {
+		plan.Filters = append(plan.Filters, fmt.Sprintf("loudnorm=I=%g:TP=-1.5:LRA=11", p.TargetLUFS))
+		applied.Steps = append(applied.Steps, StepLoudnorm)
+	}
+
+	if len(applied.Steps) > 0 {
+		plan.Applied = applied
+	}
+	return plan, nil
+}
+
+// cuts turns the leading and trailing silences and the pauses in speech into the sorted,
+// non-overlapping intervals that should be removed.
+func (p Preprocess) cuts(silences, pauses []uploaded.Cut, duration float64) []uploaded.Cut {
+	const eps = 0.05
+	var cuts []uploaded.Cut
+	if p.TrimSilence {
+		for _, s := range silences {
+			if s.Start <= eps || s.End >= duration-eps {
+				cuts = append(cuts, s)
+			}
+		}
+	}
+	if p.RemovePauses {
+		keep := p.KeepPause.Seconds()
+		for _, s := range pauses {
+			if s.Start > eps && s.End < duration-eps && s.End-s.Start > p.MaxPause.Seconds() {
+				cuts = append(cuts, uploaded.Cut{Start: s.Start + keep/2, End: s.End - keep/2})
+			}
+		}
+	}
+
+	slices.SortFunc(cuts, func(a, b uploaded.Cut) int { return cmp.Compare(a.Start, b.Start) })
+	merged := cuts[:0]
+	for _, c := range cuts {
+		if n := len(merged); n > 0 && c.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, c.End)
+			continue
+		}
+		merged = append(merged, c)
+	}
+	return merged
+}
+
+// selectFilter drops every cut from the audio. The quotes keep the commas inside the expression
+// from being read as filter separators.
+func selectFilter(cuts []uploaded.Cut) string {
+	parts := make([]string, 0, len(cuts))
+	for _, c := range cuts {
+		parts = append(parts, fmt.Sprintf("between(t,%.3f,%.3f)", c.Start, c.End))
+	}
+	return "aselect='not(" + strings.Join(parts, "+") + ")'"
+}
+
+var (
+	silenceStart = regexp.MustCompile(`silence_start: (-?[0-9.]+)`)
+	silenceEnd   = regexp.MustCompile(`silence_end: ([0-9.]+)`)
+)
+
+// detectSilence decodes file once. silencedetect reports the silent intervals on stderr, and if RemovePauses
+// is set, the voice activity detector reads the audio as 16 kHz mono PCM from stdout and returns the pauses.
+func (c Converter) detectSilence(ctx context.Context, file entities.File, duration float64) (silences, pauses []uploaded.Cut, err error) {
+	p := c.opts.Preprocess
+	if c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+
+	args := []string{
+		"-nostats",
+		"-vn",
+		"-af", fmt.Sprintf("silencedetect=noise=%gdB:d=%g", p.SilenceThreshold, p.MinSilence.Seconds()),
+	}
+	if p.RemovePauses {
+		args = append(args, "-ac", "1", "-ar", strconv.Itoa(vadRate), "-f", "s16le", "pipe:1")
+	} else {
+		args = append(args, "-f", "null", "-")
+	}
+	cmd := c.command(ctx, file.Data(), args...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("silencedetect stderr: %w", err)
+	}
+	var stdout io.ReadCloser
+	if p.RemovePauses {
+		if stdout, err = cmd.StdoutPipe(); err != nil {
+			return nil, nil, fmt.Errorf("silencedetect stdout: %w", err)
+		}
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("silencedetect start: %w", err)
+	}
+
+	var (
+		start   = -1.0
+		errTail = &tail{max: stderrTail}
+		done    = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			line := sc.Text()
+			_, _ = errTail.Write([]byte(line + "\n"))
+			if m := silenceStart.FindStringSubmatch(line); m != nil {
+				start, _ = strconv.ParseFloat(m[1], 64)
+				start = max(start, 0)
+			} else if m := silenceEnd.FindStringSubmatch(line); m != nil && start >= 0 {
+				end, _ := strconv.ParseFloat(m[1], 64)
+				silences = append(silences, uploaded.Cut{Start: start, End: end})
+				start = -1
+			}
+		}
+	}()
+
+	var vadErr error
+	if stdout != nil {
+		if pauses, vadErr = detectPauses(stdout); vadErr != nil {
+			// ffmpeg must not block on a full pipe
+			_, _ = io.Copy(io.Discard, stdout)
+		}
+	}
+	<-done
+	if err = cmd.Wait(); err != nil {
+		return nil, nil, fmt.Errorf("silencedetect error: %w: %s", err, errTail.String())
+	}
+	if vadErr != nil {
+		return nil, nil, fmt.Errorf("voice activity detection: %w", vadErr)
+	}
+
+	// silence running until the end of the file has no silence_end
+	if start >= 0 {
+		silences = append(silences, uploaded.Cut{Start: start, End: duration})
+	}
+	return silences, pauses, nil
+}
@@ -0,0 +1,186 @@
+package converter
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/kxddry/lectura/shared/entities/uploaded"
+	"io"
+	"math"
+	"math/cmplx"
+)
+
+// The voice activity detector follows Moattar and Homayounpour, "A simple but efficient real-time voice
+// activity detection algorithm" (EUSIPCO 2009). Every 10 ms frame is compared with the background noise
+// in three features: energy, dominant frequency and spectral flatness. The paper takes a frame for speech
+// if two of them stand out; here the spectrum must always be one of them, so loud but flat noise
+// (fans, traffic, a door) is not speech, while quiet voiced speech is.
+const (
+	vadRate       = 16000 // Hz of the PCM the detector reads
+	vadFrame      = 160   // samples in a frame, 10 ms
+	vadFFT        = 256   // FFT size, the frame is zero padded
+	vadEnergyDB   = 10    // energy above the background for a frame to stand out
+	vadFreqHz     = 185   // dominant frequency above the background
+	vadFlatnessDB = 5     // spectral flatness below the background, in dB of the geometric to arithmetic mean
+	vadFloorDB    = -70   // frames quieter than this are silence whatever their spectrum
+	vadMinSilence = 10    // frames; shorter silences are taken for speech, e.g. stops between words
+	vadMinSpeech  = 5     // frames; shorter speech is taken for silence, e.g. clicks
+	vadMemory     = 100   // silent frames the background is averaged over, so it follows a changing room
+)
+
+// vad classifies frames of 16 kHz mono audio as speech or silence.
+type vad struct {
+	speech []bool // per frame
+
+	// background noise
+	energy, freq, flatness float64
+	silent                 int
+
+	window [vadFrame]float64
+	buf    [vadFFT]complex128
+}
+
+func newVAD() *vad {
+	v := &vad{}
+	for i := range v.window {
+		v.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(vadFrame-1))
+	}
+	return v
+}
+
+// detectPauses reads 16 kHz mono signed 16-bit little-endian PCM from r and returns the intervals without speech.
+func detectPauses(r io.Reader) ([]uploaded.Cut, error) {
+	v := newVAD()
+	buf := make([]byte, 2*vadFrame)
+	frame := make([]float64, vadFrame)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, err
+		}
+		for i := range frame {
+			frame[i] = float64(int16(binary.LittleEndian.Uint16(buf[2*i:]))) / 32768
+		}
+		v.push(frame)
+	}
+	return v.pauses(), nil
+}
+
+// push classifies the next frame.
+func (v *vad) push(frame []float64) {
+	energy, freq, flatness := v.features(frame)
+	energy = max(energy, vadFloorDB)
+	if len(v.speech) == 0 {
+		v.energy, v.freq, v.flatness = energy, freq, flatness
+	}
+
+	speech := false
+	if energy > vadFloorDB && v.flatness-flatness >= vadFlatnessDB {
+		speech = energy-v.energy >= vadEnergyDB || freq-v.freq >= vadFreqHz
+	}
+	v.speech = append(v.speech, speech)
+
+	// the background is the average of the silent frames, and never louder than the quietest frame
+	if !speech {
+		n := float64(min(v.silent, vadMemory))
+		v.energy = (n*v.energy + energy) / (n + 1)
+		v.freq = (n*v.freq + freq) / (n + 1)
+		v.flatness = (n*v.flatness + flatness) / (n + 1)
+		v.silent++
+	}
+	v.energy = min(v.energy, energy)
+	v.freq = min(v.freq, freq)
+	v.flatness = max(v.flatness, flatness)
+}
+
+// features returns the energy of a frame in dBFS, its dominant frequency in Hz and its spectral flatness in dB,
+// 0 for white noise and negative for a spectrum with peaks, like the harmonics of a voice.
+func (v *vad) features(frame []float64) (energy, freq, flatness float64) {
+	var sum float64
+	for i, x := range frame {
+		sum += x * x
+		v.buf[i] = complex(x*v.window[i], 0)
+	}
+	for i := len(frame); i < vadFFT; i++ {
+		v.buf[i] = 0
+	}
+	energy = 10 * math.Log10(sum/float64(len(frame))+1e-12)
+
+	fft(v.buf[:])
+	var peak, logSum, linSum float64
+	bins := vadFFT / 2
+	for k := 1; k <= bins; k++ {
+		p := real(v.buf[k])*real(v.buf[k]) + imag(v.buf[k])*imag(v.buf[k]) + 1e-20
+		if p > peak {
+			peak, freq = p, float64(k*vadRate)/vadFFT
+		}
+		logSum += math.Log(p)
+		linSum += p
+	}
+	geometric, arithmetic := math.Exp(logSum/float64(bins)), linSum/float64(bins)
+	return energy, freq, 10 * math.Log10(geometric/arithmetic)
+}
+
+// pauses returns the runs of silent frames, after dropping runs too short to count.
+func (v *vad) pauses() []uploaded.Cut {
+	speech := append([]bool(nil), v.speech...)
+	flip(speech, true, vadMinSpeech)
+	flip(speech, false, vadMinSilence)
+
+	const frameSeconds = float64(vadFrame) / vadRate
+	var out []uploaded.Cut
+	for i := 0; i < len(speech); {
+		j := i
+		for j < len(speech) && speech[j] == speech[i] {
+			j++
+		}
+		if !speech[i] {
+			out = append(out, uploaded.Cut{Start: float64(i) * frameSeconds, End: float64(j) * frameSeconds})
+		}
+		i = j
+	}
+	return out
+}
+
+// flip turns runs of value shorter than n frames into the opposite value.
+func flip(frames []bool, value bool, n int) {
+	for i := 0; i < len(frames); {
+		j := i
+		for j < len(frames) && frames[j] == frames[i] {
+			j++
+		}
+		if frames[i] == value && j-i < n {
+			for k := i; k < j; k++ {
+				frames[k] = !value
+			}
+		}
+		i = j
+	}
+}
+
+// fft transforms x in place; len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}